@@ -0,0 +1,143 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// atomicFile is a temporary file which replaces path once committed. Readers of path
+// observe either the previous contents or everything written, never a partial write.
+type atomicFile struct {
+	*os.File
+
+	dir  localFS
+	tmp  string // name of the temporary file in dir
+	path string
+	done bool
+}
+
+// createAtomic creates a temporary file in the same directory as path so the final
+// rename stays on one filesystem.
+//
+// Like os.WriteFile the file is created with perm less the umask, and replacing an
+// existing file keeps its permissions.
+func createAtomic(dir localFS, path string, perm fs.FileMode) (*atomicFile, error) {
+	parent, base := filepath.Split(path)
+	existing, err := dir.Stat(path)
+	keepMode := err == nil && existing.Mode().IsRegular()
+	if keepMode {
+		perm = existing.Mode().Perm()
+	}
+
+	// os.CreateTemp always uses 0600, so the file is opened here to let the
+	// kernel apply the umask.
+	var fd *os.File
+	var tmp string
+	for range 10000 {
+		tmp = filepath.Join(parent, "."+base+".tmp-"+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		fd, err = dir.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+
+	// The umask doesn't apply to an existing file's permissions
+	if keepMode {
+		if err := fd.Chmod(perm); err != nil && runtime.GOOS != "windows" {
+			fd.Close()
+			dir.Remove(tmp)
+			return nil, fmt.Errorf("chmod temp file: %w", err)
+		}
+	}
+	return &atomicFile{File: fd, dir: dir, tmp: tmp, path: path}, nil
+}
+
+// Commit flushes the temporary file to disk and renames it over path, then syncs the
+// parent directory so the rename itself is durable.
+func (f *atomicFile) Commit() error {
+	if f.done {
+		return os.ErrClosed
+	}
+	f.done = true
+
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		f.dir.Remove(f.tmp)
+		return fmt.Errorf("fsync temp file: %w", err)
+	}
+	if err := f.File.Close(); err != nil {
+		f.dir.Remove(f.tmp)
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := f.dir.Rename(f.tmp, f.path); err != nil {
+		f.dir.Remove(f.tmp)
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+	if err := syncDir(f.dir, filepath.Dir(f.path)); err != nil {
+		return fmt.Errorf("fsync directory: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the temporary file and leaves path untouched.
+func (f *atomicFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	return errors.Join(f.File.Close(), f.dir.Remove(f.tmp))
+}
+
+func syncDir(dir localFS, name string) error {
+	// Windows does not support syncing directories
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fd, err := dir.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+func writeFileAtomic(dir localFS, path string, data []byte, perm fs.FileMode) error {
+	f, err := createAtomic(dir, path, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	return f.Commit()
+}
@@ -0,0 +1,125 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.txt")
+
+	t.Run("create and replace", func(t *testing.T) {
+		require.NoError(t, writeFileAtomic(osFS{}, path, []byte("first"), 0600))
+		require.NoError(t, writeFileAtomic(osFS{}, path, []byte("second"), 0600))
+
+		bs, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "second", string(bs))
+
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+		requireNoTempFiles(t, dir)
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("windows doesn't have unix permissions")
+		}
+
+		// The umask applies to new files
+		path := filepath.Join(dir, "perm.txt")
+		require.NoError(t, writeFileAtomic(osFS{}, path, []byte("data"), 0666))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, 0666&^currentUmask(t), info.Mode().Perm())
+
+		// Existing files keep their permissions
+		require.NoError(t, os.Chmod(path, 0640))
+		require.NoError(t, writeFileAtomic(osFS{}, path, []byte("data"), 0600))
+
+		info, err = os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		requireNoTempFiles(t, dir)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		err := writeFileAtomic(osFS{}, filepath.Join(dir, "missing", "data.txt"), []byte("data"), 0600)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.ErrorContains(t, err, "create temp file")
+	})
+
+	t.Run("rename failure", func(t *testing.T) {
+		target := filepath.Join(dir, "subdir")
+		require.NoError(t, os.MkdirAll(filepath.Join(target, "child"), 0700))
+
+		err := writeFileAtomic(osFS{}, target, []byte("data"), 0600)
+		require.ErrorContains(t, err, "rename temp file")
+		requireNoTempFiles(t, dir)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		f, err := createAtomic(osFS{}, path, 0600)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("partial"))
+		require.NoError(t, err)
+		require.NoError(t, f.Abort())
+
+		bs, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "second", string(bs))
+		requireNoTempFiles(t, dir)
+
+		require.ErrorIs(t, f.Commit(), os.ErrClosed)
+	})
+}
+
+func requireNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		require.False(t, strings.Contains(e.Name(), ".tmp-"), "found temp file %s", e.Name())
+	}
+}
+
+// currentUmask returns the process umask by creating a file with every permission.
+func currentUmask(t *testing.T) os.FileMode {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "umask")
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0777)
+	require.NoError(t, err)
+	info, err := fd.Stat()
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+	return 0777 &^ info.Mode().Perm()
+}
@@ -24,14 +24,17 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/moov-io/cryptfs"
+	"github.com/moov-io/cryptfs/stream"
 )
 
 var (
 	flagDecrypt = flag.String("decrypt", "", "Filepath to load and attempt decryption")
 	flagEncrypt = flag.String("encrypt", "", "Filepath to load and attempt encryption")
+	flagRewrap  = flag.String("rewrap", "", "Filepath of a streaming file to rewrap the data key of with Vault")
 	flagOutput  = flag.String("output", "", "Optional filepath to write final contents into")
 	flagVerbose = flag.Bool("verbose", false, "Enable verbose logging")
 
@@ -43,11 +46,22 @@ var (
 Configure AES encryption with the specified key. Can also be a filepath.
 Prefix value with 'base64:' to decode key.
 `))
+
+	// Vault flags
+	flagVaultAddress = flag.String("vault-address", os.Getenv("VAULT_ADDR"), "Address of the Vault server")
+	flagVaultToken   = flag.String("vault-token", os.Getenv("VAULT_TOKEN"), "Token to authenticate with Vault")
+	flagVaultKey     = flag.String("vault-key", "", "Name of the Vault transit key which wraps data keys")
+	flagVaultNewKey  = flag.String("vault-new-key", "", "Transit key to rewrap data keys with, defaults to -vault-key")
 )
 
 func main() {
 	flag.Parse()
 
+	// The output is truncated before the input is read
+	if *flagRewrap != "" && filepath.Clean(*flagOutput) == filepath.Clean(*flagRewrap) {
+		log.Fatalf("ERROR: -rewrap can't write to its input, use a different -output")
+	}
+
 	output := setupOutput(*flagOutput)
 	defer output.Close()
 
@@ -79,6 +93,15 @@ func main() {
 			log.Fatalf("ERROR writing output: %v", err)
 		}
 
+	case *flagRewrap != "":
+		oldKP, newKP, err := setupRewrapKeyProviders()
+		if err != nil {
+			log.Fatalf("ERROR creating key providers: %v", err)
+		}
+		if err := rewrap(oldKP, newKP, *flagRewrap, output); err != nil {
+			log.Fatalf("ERROR during rewrap: %v", err)
+		}
+
 	default:
 		log.Fatalf("ERROR: no action specified")
 	}
@@ -134,6 +157,31 @@ func setupCryptfs() (*cryptfs.FS, error) {
 	return fs, nil
 }
 
+func setupRewrapKeyProviders() (stream.KeyProvider, stream.KeyProvider, error) {
+	conf := cryptfs.VaultConfig{
+		Address: *flagVaultAddress,
+		Token: &cryptfs.TokenConfig{
+			Token: *flagVaultToken,
+		},
+		KeyName: *flagVaultKey,
+	}
+	oldKP, err := cryptfs.NewVaultKeyProvider(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rotated transit keys wrap with their latest version, so the same key works for both
+	if *flagVaultNewKey == "" || *flagVaultNewKey == *flagVaultKey {
+		return oldKP, oldKP, nil
+	}
+	conf.KeyName = *flagVaultNewKey
+	newKP, err := cryptfs.NewVaultKeyProvider(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return oldKP, newKP, nil
+}
+
 func openAESCryptor(pathOrValue string) (*cryptfs.AESCryptor, error) {
 	data, err := readFile(pathOrValue)
 	if err != nil {
@@ -183,3 +231,13 @@ func encrypt(cc *cryptfs.FS, path string) ([]byte, error) {
 	}
 	return cc.Disfigure(raw)
 }
+
+func rewrap(oldKP, newKP stream.KeyProvider, path string, w io.Writer) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %s -- %v", path, err)
+	}
+	defer fd.Close()
+
+	return stream.Rewrap(fd, w, oldKP, newKP)
+}
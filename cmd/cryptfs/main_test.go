@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/moov-io/cryptfs"
+	"github.com/moov-io/cryptfs/stream"
 	"github.com/stretchr/testify/require"
 )
 
@@ -44,3 +49,53 @@ func TestCryptfsErr(t *testing.T) {
 	_, err = encrypt(fs, "/does/not/exist")
 	require.Error(t, err)
 }
+
+// labelKeyProvider wraps data keys by prefixing them with its label.
+type labelKeyProvider struct {
+	label string
+}
+
+func (p labelKeyProvider) GenerateKey() (*stream.DataKey, error) {
+	key := []byte(strings.Repeat("2", 16))
+	wrapped, err := p.WrapKey(key)
+	return &stream.DataKey{Plaintext: key, WrappedKey: wrapped}, err
+}
+
+func (p labelKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	return append([]byte(p.label), plaintext...), nil
+}
+
+func (p labelKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	key, ok := bytes.CutPrefix(wrappedKey, []byte(p.label))
+	if !ok {
+		return nil, errors.New("wrong label")
+	}
+	return key, nil
+}
+
+func TestRewrap(t *testing.T) {
+	oldKP := labelKeyProvider{label: "old:"}
+	newKP := labelKeyProvider{label: "new:"}
+
+	var buf bytes.Buffer
+	w, err := stream.NewWriter(&buf, oldKP)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("abcdef"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	path := filepath.Join(t.TempDir(), "file.enc")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+
+	var out bytes.Buffer
+	require.NoError(t, rewrap(oldKP, newKP, path, &out))
+
+	r, err := stream.NewReader(&out, newKP)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", string(got))
+
+	err = rewrap(oldKP, newKP, "/does/not/exist", io.Discard)
+	require.Error(t, err)
+}
@@ -24,9 +24,10 @@ import (
 
 // Coder is an interface describing two operations which transform data into
 // another format. This can be done to compress or disfigure bytes.
+// Implementations outside of this package can be used with SetCoder.
 type Coder interface {
-	encode(data []byte) ([]byte, error)
-	decode(data []byte) ([]byte, error)
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
 }
 
 // NoEncoding is a Coder which does not transform data.
@@ -36,11 +37,11 @@ func NoEncoding() Coder {
 
 type nothingCoder struct{}
 
-func (*nothingCoder) encode(data []byte) ([]byte, error) {
+func (*nothingCoder) Encode(data []byte) ([]byte, error) {
 	return data, nil
 }
 
-func (*nothingCoder) decode(data []byte) ([]byte, error) {
+func (*nothingCoder) Decode(data []byte) ([]byte, error) {
 	return data, nil
 }
 
@@ -52,13 +53,13 @@ func Base64() Coder {
 
 type base64Coder struct{}
 
-func (c *base64Coder) encode(data []byte) ([]byte, error) {
+func (c *base64Coder) Encode(data []byte) ([]byte, error) {
 	ebuf := make([]byte, base64.RawStdEncoding.EncodedLen(len(data)))
 	base64.RawStdEncoding.Encode(ebuf, data)
 	return ebuf, nil
 }
 
-func (c *base64Coder) decode(data []byte) ([]byte, error) {
+func (c *base64Coder) Decode(data []byte) ([]byte, error) {
 	dbuf := make([]byte, base64.RawStdEncoding.DecodedLen(len(data)))
 	_, err := base64.RawStdEncoding.Decode(dbuf, data)
 	if err != nil {
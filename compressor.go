@@ -17,21 +17,24 @@
 
 package cryptfs
 
+// Compressor is an interface describing two operations which compress and decompress
+// data. Implementations outside of this package can be used with SetCompression.
 type Compressor interface {
-	compress(data []byte) ([]byte, error)
-	decompress(data []byte) ([]byte, error)
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
 }
 
+// NoCompression is a Compressor which does not transform data.
 func NoCompression() Compressor {
 	return &nothingCompressor{}
 }
 
 type nothingCompressor struct{}
 
-func (*nothingCompressor) compress(data []byte) ([]byte, error) {
+func (*nothingCompressor) Compress(data []byte) ([]byte, error) {
 	return data, nil
 }
 
-func (*nothingCompressor) decompress(data []byte) ([]byte, error) {
+func (*nothingCompressor) Decompress(data []byte) ([]byte, error) {
 	return data, nil
 }
@@ -92,7 +92,7 @@ func (g *gzipCompressor) getPoolIndex() int {
 	return l
 }
 
-func (g *gzipCompressor) compress(data []byte) ([]byte, error) {
+func (g *gzipCompressor) Compress(data []byte) ([]byte, error) {
 	if len(data) == 0 {
 		return nil, nil
 	}
@@ -125,7 +125,7 @@ func (g *gzipCompressor) compress(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (g *gzipCompressor) decompress(data []byte) ([]byte, error) {
+func (g *gzipCompressor) Decompress(data []byte) ([]byte, error) {
 	if len(data) == 0 {
 		return nil, nil
 	}
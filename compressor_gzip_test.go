@@ -29,33 +29,33 @@ import (
 func TestGzip(t *testing.T) {
 	gz := Gzip()
 	plain := []byte("hello, world")
-	compressed, err := gz.compress(plain)
+	compressed, err := gz.Compress(plain)
 	require.NoError(t, err)
 
 	t.Run("not compressed equals compressed", func(t *testing.T) {
-		decompressed, err := gz.decompress(plain)
+		decompressed, err := gz.Decompress(plain)
 		require.NoError(t, err)
 		require.Equal(t, plain, decompressed)
 	})
 
 	t.Run("compressed can be decompressed", func(t *testing.T) {
-		decompressed, err := gz.decompress(compressed)
+		decompressed, err := gz.Decompress(compressed)
 		require.NoError(t, err)
 		require.Equal(t, plain, decompressed)
 	})
 
 	t.Run("empty", func(t *testing.T) {
-		out, err := gz.compress(nil)
+		out, err := gz.Compress(nil)
 		require.NoError(t, err)
 
-		out, err = gz.decompress(out)
+		out, err = gz.Decompress(out)
 		require.NoError(t, err)
 		require.Len(t, out, 0)
 
 		require.Equal(t, "", string(out))
 
 		// nil input
-		out, err = gz.decompress(nil)
+		out, err = gz.Decompress(nil)
 		require.NoError(t, err)
 		require.Len(t, out, 0)
 
@@ -66,17 +66,17 @@ func TestGzip(t *testing.T) {
 func TestGzipLevel(t *testing.T) {
 	gz := GzipLevel(gzip.BestCompression)
 	plain := []byte("hello, world")
-	compressed, err := gz.compress(plain)
+	compressed, err := gz.Compress(plain)
 	require.NoError(t, err)
 
 	t.Run("not compressed equals compressed", func(t *testing.T) {
-		decompressed, err := gz.decompress(plain)
+		decompressed, err := gz.Decompress(plain)
 		require.NoError(t, err)
 		require.Equal(t, plain, decompressed)
 	})
 
 	t.Run("compressed can be decompressed", func(t *testing.T) {
-		decompressed, err := gz.decompress(compressed)
+		decompressed, err := gz.Decompress(compressed)
 		require.NoError(t, err)
 		require.Equal(t, plain, decompressed)
 	})
@@ -85,17 +85,17 @@ func TestGzipLevel(t *testing.T) {
 func TestGzipRequired(t *testing.T) {
 	gz := GzipRequired(gzip.BestSpeed)
 	plain := []byte("hello, world")
-	compressed, err := gz.compress(plain)
+	compressed, err := gz.Compress(plain)
 	require.NoError(t, err)
 
 	t.Run("not compressed is rejected", func(t *testing.T) {
-		decompressed, err := gz.decompress(plain)
+		decompressed, err := gz.Decompress(plain)
 		require.ErrorIs(t, err, gzip.ErrHeader)
 		require.Nil(t, decompressed)
 	})
 
 	t.Run("compressed can be decompressed", func(t *testing.T) {
-		decompressed, err := gz.decompress(compressed)
+		decompressed, err := gz.Decompress(compressed)
 		require.NoError(t, err)
 		require.Equal(t, plain, decompressed)
 	})
@@ -113,11 +113,11 @@ func BenchmarkCompression_Gzip(b *testing.B) {
 			input := []byte(strings.Repeat("hello world", 1432))
 
 			for b.Loop() {
-				out, err := gz.compress(input)
+				out, err := gz.Compress(input)
 				require.NoError(b, err)
 				require.NotEmpty(b, out)
 
-				plain, err := gz.decompress(out)
+				plain, err := gz.Decompress(out)
 				require.NoError(b, err)
 
 				require.Equal(b, len(input), len(plain))
@@ -1,14 +1,19 @@
 package cryptfs
 
 import (
+	"errors"
 	"fmt"
 	"os"
+
+	"github.com/moov-io/cryptfs/stream"
 )
 
 type Config struct {
 	Compression CompressionConfig `json:"compression" yaml:"compression"`
 	Encryption  EncryptionConfig  `json:"encryption" yaml:"encryption"`
 	Encoding    EncodingConfig    `json:"encoding" yaml:"encoding"`
+	Envelope    *EnvelopeConfig   `json:"envelope" yaml:"envelope"`
+	Stream      *StreamConfig     `json:"stream" yaml:"stream"`
 
 	HMACKey string `json:"hmacKey" yaml:"hmacKey"`
 }
@@ -43,12 +48,71 @@ type EncodingConfig struct {
 	Base64 bool `json:"base64" yaml:"base64"`
 }
 
+// EnvelopeConfig enables a versioned header on encrypted data which records the
+// algorithms and key ID used.
+type EnvelopeConfig struct {
+	KeyID string `json:"keyID" yaml:"keyID"`
+}
+
+// StreamConfig makes writes use the CRFS stream format from the stream package.
+// Encryption must be configured with AES or Vault, which wrap a random data key for
+// each stream. Gzip compression at Compression.Gzip.Level is used when
+// Compression.Gzip is set and Codec is empty.
+type StreamConfig struct {
+	// ChunkSize is the plaintext size of each encrypted chunk. Zero uses stream.DefaultChunkSize.
+	ChunkSize int `json:"chunkSize" yaml:"chunkSize"`
+
+	// Concurrency is the number of chunks encrypted or decrypted in parallel.
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+
+	// Cipher is the suite which encrypts chunks: aes-gcm (default), chacha20-poly1305
+	// or xchacha20-poly1305. The ChaCha20 suites require 256-bit keys.
+	Cipher string `json:"cipher" yaml:"cipher"`
+
+	// Codec compresses data before encryption: gzip, flate or zstd. It takes the
+	// place of Compression.Gzip for streams.
+	Codec string `json:"codec" yaml:"codec"`
+
+	// CompressionLevel is the level of Codec, zero uses the codec's default.
+	CompressionLevel int `json:"compressionLevel" yaml:"compressionLevel"`
+}
+
+var streamCiphers = map[string]stream.Cipher{
+	"aes-gcm":            stream.AESGCM,
+	"chacha20-poly1305":  stream.ChaCha20Poly1305,
+	"xchacha20-poly1305": stream.XChaCha20Poly1305,
+}
+
+var streamCodecs = map[string]stream.Codec{
+	"none":  stream.CodecNone,
+	"gzip":  stream.CodecGzip,
+	"flate": stream.CodecFlate,
+	"zstd":  stream.CodecZstd,
+}
+
+// aesKeyProvider wraps a random data key for each stream with key, and reads streams
+// written with stream.NewStaticKeyProvider(key) which have no wrapped key.
+func aesKeyProvider(key []byte) (stream.KeyProvider, error) {
+	kwp, err := stream.NewKeyWrapProvider(key)
+	if err != nil {
+		return nil, err
+	}
+	return stream.NewRoutingKeyProvider(stream.RoutingConfig{
+		Write: "aes-kwp",
+		Routes: []stream.Route{
+			{Name: "aes-kwp", Provider: kwp},
+			{Name: "static", Provider: stream.NewStaticKeyProvider(key)},
+		},
+	})
+}
+
 // FromConfig will create a *FS from the given Config
 func FromConfig(conf Config) (*FS, error) {
 	var err error
 
 	// Encryption
 	cryptor := NoEncryption()
+	var keyProvider stream.KeyProvider
 	switch {
 	case conf.Encryption.AES != nil:
 		var key []byte
@@ -61,6 +125,9 @@ func FromConfig(conf Config) (*FS, error) {
 			}
 		}
 		cryptor, err = NewAESCryptor(key)
+		if err == nil {
+			keyProvider, err = aesKeyProvider(key)
+		}
 
 	case conf.Encryption.GPG != nil:
 		if conf.Encryption.GPG.PublicPath != "" && conf.Encryption.GPG.PrivatePath == "" {
@@ -76,7 +143,12 @@ func FromConfig(conf Config) (*FS, error) {
 		}
 
 	case conf.Encryption.Vault != nil:
-		cryptor, err = NewVaultCryptor(*conf.Encryption.Vault)
+		var vc *VaultCryptor
+		vc, err = NewVaultCryptor(*conf.Encryption.Vault)
+		if err == nil {
+			cryptor = vc
+			keyProvider = &vaultKeyProvider{vaultClient: vc.vaultClient}
+		}
 	}
 	if err != nil {
 		return nil, fmt.Errorf("cryptor from config: %w", err)
@@ -88,6 +160,11 @@ func FromConfig(conf Config) (*FS, error) {
 		return nil, fmt.Errorf("cryptfs from config: %w", err)
 	}
 
+	// Read CRFS streams with the same keys
+	if keyProvider != nil {
+		fsys.SetKeyProvider(keyProvider)
+	}
+
 	// Compression
 	if conf.Compression.Gzip != nil {
 		compressor := Gzip()
@@ -105,6 +182,46 @@ func FromConfig(conf Config) (*FS, error) {
 		fsys.SetCoder(Base64())
 	}
 
+	// Stream format
+	if conf.Stream != nil {
+		if keyProvider == nil {
+			return nil, errors.New("stream from config: requires AES or Vault encryption")
+		}
+		var opts []stream.Option
+		if conf.Stream.ChunkSize > 0 {
+			opts = append(opts, stream.WithChunkSize(conf.Stream.ChunkSize))
+		}
+		if conf.Stream.Concurrency > 1 {
+			opts = append(opts, stream.WithConcurrency(conf.Stream.Concurrency))
+		}
+		if conf.Stream.Cipher != "" {
+			c, ok := streamCiphers[conf.Stream.Cipher]
+			if !ok {
+				return nil, fmt.Errorf("stream from config: unknown cipher %q", conf.Stream.Cipher)
+			}
+			opts = append(opts, stream.WithCipher(c))
+		}
+		switch {
+		case conf.Stream.Codec != "":
+			codec, ok := streamCodecs[conf.Stream.Codec]
+			if !ok {
+				return nil, fmt.Errorf("stream from config: unknown codec %q", conf.Stream.Codec)
+			}
+			opts = append(opts, stream.WithCompressionCodec(codec, conf.Stream.CompressionLevel))
+		case conf.Compression.Gzip != nil:
+			opts = append(opts, stream.WithCompressionCodec(stream.CodecGzip, conf.Compression.Gzip.Level))
+		}
+		fsys.SetStreamFormat(opts...)
+	}
+
+	// Envelope
+	if conf.Envelope != nil {
+		fsys.SetEnvelope(true)
+		if err := fsys.SetKeyID(conf.Envelope.KeyID); err != nil {
+			return nil, fmt.Errorf("envelope from config: %w", err)
+		}
+	}
+
 	if len(conf.HMACKey) > 0 {
 		fsys.SetHMACKey([]byte(conf.HMACKey))
 	}
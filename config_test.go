@@ -64,6 +64,20 @@ func TestFromConfig(t *testing.T) {
 		testCryptFS(t, fsys)
 	})
 
+	t.Run("AES with envelope", func(t *testing.T) {
+		conf := conf
+		conf.Envelope = &EnvelopeConfig{
+			KeyID: "key-1",
+		}
+
+		fsys, err := FromConfig(conf)
+		require.NoError(t, err)
+		require.True(t, fsys.envelope)
+		require.Equal(t, "key-1", fsys.keyID)
+
+		testCryptFS(t, fsys)
+	})
+
 	t.Run("AES - filepath error", func(t *testing.T) {
 		conf.Encryption.AES.Key = ""
 		conf.Encryption.AES.KeyPath = "/does/not/exist"
@@ -18,12 +18,16 @@
 package cryptfs
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
+
+	"github.com/moov-io/cryptfs/stream"
 )
 
 type FS struct {
@@ -32,6 +36,23 @@ type FS struct {
 	coder      Coder
 
 	hmacKey []byte
+
+	keyProvider       stream.KeyProvider
+	streamFormat      bool // write the CRFS stream format
+	streamOptions     []stream.Option
+	streamReadOptions []stream.Option // only passed to stream.NewReader
+
+	// envelope enables writing a versioned header describing how data was produced
+	envelope bool
+	keyID    string
+	cryptors map[string]Cryptor // additional cryptors by key ID for reading envelopes
+
+	// base is the filesystem files are read from. When nil names are
+	// local paths and read with the os package.
+	base fs.FS
+	// root is the local directory base refers to, if any. Writes are
+	// resolved against root.
+	root *os.Root
 }
 
 // New returns a FS instance with the specified Cryptor used for all operations.
@@ -75,21 +96,138 @@ func (fsys *FS) SetHMACKey(key []byte) {
 	}
 }
 
-// Open will open a file at the given name
+// SetKeyProvider configures the KeyProvider used with the stream package. Data in the
+// CRFS stream format is detected and decrypted with it by Reveal, ReadFile and Open,
+// unless an HMAC key is set without SetStreamFormat.
+func (fsys *FS) SetKeyProvider(kp stream.KeyProvider) {
+	if fsys != nil && kp != nil {
+		fsys.keyProvider = kp
+	}
+}
+
+// Open will open a file at the given name. Regular files are read and decrypted so
+// the returned fs.File yields plaintext and reports the plaintext size from Stat.
+// Directories are returned as-is.
 func (fsys *FS) Open(name string) (fs.File, error) {
-	fd, err := os.Open(name)
+	fd, err := fsys.openRaw(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s failed: %w", name, err)
+	}
+	info, err := fd.Stat()
 	if err != nil {
+		fd.Close()
 		return nil, fmt.Errorf("opening %s failed: %w", name, err)
 	}
-	return fd, nil
+	if info.IsDir() {
+		if d, ok := fd.(fs.ReadDirFile); ok {
+			return &dir{ReadDirFile: d, fsys: fsys, name: name}, nil
+		}
+		return fd, nil
+	}
+
+	sf, encodedBytes, err := fsys.openStream(name, fd, info)
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("reading %s failed: %w", name, err)
+	}
+	if sf != nil {
+		return sf, nil
+	}
+	fd.Close()
+
+	bs, err := fsys.Reveal(encodedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s failed: %w", name, err)
+	}
+	return newFile(info, bs), nil
+}
+
+// Stat returns a FileInfo describing the named file. The size reported for CRFS streams
+// is their plaintext size, which is read from the stream's trailer on the first call to
+// Size. Files aren't decrypted, so other formats report their size on disk.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.statRaw(name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.plaintextInfo(name, info), nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by filename.
+// Entries report sizes from Info like Stat.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fsys.readDirRaw(name)
+	return fsys.wrapDirEntries(name, entries), err
+}
+
+// Glob returns the names of all files matching pattern.
+func (fsys *FS) Glob(pattern string) ([]string, error) {
+	if fsys.base == nil {
+		return filepath.Glob(pattern)
+	}
+	return fs.Glob(fsys.base, pattern)
+}
+
+// Sub returns a *FS corresponding to the subtree rooted at dir. The returned FS shares
+// the Cryptor, Compressor, Coder and HMAC key of fsys.
+func (fsys *FS) Sub(dir string) (fs.FS, error) {
+	sub := *fsys
+
+	if fsys.base == nil {
+		// Names are local paths, so root the returned FS at dir
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		sub.root = root
+		sub.base = root.FS()
+		return &sub, nil
+	}
+
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	if fsys.root != nil {
+		root, err := fsys.root.OpenRoot(filepath.FromSlash(dir))
+		if err != nil {
+			return nil, err
+		}
+		sub.root = root
+		sub.base = root.FS()
+		return &sub, nil
+	}
+	base, err := fs.Sub(fsys.base, dir)
+	if err != nil {
+		return nil, err
+	}
+	sub.base = base
+	return &sub, nil
 }
 
 // Reveal will decode and then decrypt the bytes its given.
+//
+// Data in the CRFS stream format (see the stream package) is detected and decrypted
+// with the configured KeyProvider. When an HMAC key is set streams are only accepted
+// after SetStreamFormat, otherwise all data must carry the MAC.
 func (fsys *FS) Reveal(encodedBytes []byte) ([]byte, error) {
-	bs, err := fsys.coder.decode(encodedBytes)
+	return fsys.RevealContext(context.Background(), encodedBytes)
+}
+
+// RevealContext is like Reveal but passes ctx to the Cryptor so remote operations
+// can be canceled.
+func (fsys *FS) RevealContext(ctx context.Context, encodedBytes []byte) ([]byte, error) {
+	readsStreams := fsys.readsStreams()
+	if readsStreams && stream.HasHeader(encodedBytes) {
+		return fsys.revealStream(ctx, encodedBytes)
+	}
+
+	bs, err := fsys.coder.Decode(encodedBytes)
 	if err != nil {
 		return nil, fmt.Errorf("decoding: %w", err)
 	}
+	if readsStreams && stream.HasHeader(bs) {
+		return fsys.revealStream(ctx, bs)
+	}
 
 	// Verify MAC if hmacKey is set
 	if len(fsys.hmacKey) > 1 {
@@ -107,12 +245,29 @@ func (fsys *FS) Reveal(encodedBytes []byte) ([]byte, error) {
 		}
 	}
 
-	bs, err = fsys.cryptor.decrypt(bs)
+	cryptor, compressor := fsys.cryptor, fsys.compressor
+	if hasEnvelope(bs) {
+		var env *envelope
+		env, bs, err = parseEnvelope(bs)
+		if err != nil {
+			return nil, fmt.Errorf("envelope: %w", err)
+		}
+		cryptor, err = fsys.envelopeCryptor(env)
+		if err != nil {
+			return nil, fmt.Errorf("envelope: %w", err)
+		}
+		compressor, err = fsys.envelopeCompressor(env, len(fsys.hmacKey) > 1)
+		if err != nil {
+			return nil, fmt.Errorf("envelope: %w", err)
+		}
+	}
+
+	bs, err = decryptContext(ctx, cryptor, bs)
 	if err != nil {
 		return nil, fmt.Errorf("decryption: %w", err)
 	}
 
-	bs, err = fsys.compressor.decompress(bs)
+	bs, err = compressor.Decompress(bs)
 	if err != nil {
 		return nil, fmt.Errorf("decompression: %w", err)
 	}
@@ -121,11 +276,16 @@ func (fsys *FS) Reveal(encodedBytes []byte) ([]byte, error) {
 
 // ReadFile will attempt to open, decode, and decrypt a file.
 func (fsys *FS) ReadFile(name string) ([]byte, error) {
-	encodedBytes, err := os.ReadFile(name)
+	return fsys.ReadFileContext(context.Background(), name)
+}
+
+// ReadFileContext is like ReadFile but passes ctx to the Cryptor.
+func (fsys *FS) ReadFileContext(ctx context.Context, name string) ([]byte, error) {
+	encodedBytes, err := fsys.readRaw(name)
 	if err != nil {
 		return nil, err
 	}
-	bs, err := fsys.Reveal(encodedBytes)
+	bs, err := fsys.RevealContext(ctx, encodedBytes)
 	if err != nil {
 		return nil, fmt.Errorf("reading %s failed: %w", name, err)
 	}
@@ -134,23 +294,38 @@ func (fsys *FS) ReadFile(name string) ([]byte, error) {
 
 // Disfigure will encrypt and encode the plaintext
 func (fsys *FS) Disfigure(plaintext []byte) ([]byte, error) {
-	bs, err := fsys.compressor.compress(plaintext)
+	return fsys.DisfigureContext(context.Background(), plaintext)
+}
+
+// DisfigureContext is like Disfigure but passes ctx to the Cryptor so remote operations
+// can be canceled.
+func (fsys *FS) DisfigureContext(ctx context.Context, plaintext []byte) ([]byte, error) {
+	if fsys.streamFormat {
+		return fsys.disfigureStream(ctx, plaintext)
+	}
+
+	bs, err := fsys.compressor.Compress(plaintext)
 	if err != nil {
 		return nil, fmt.Errorf("compression: %w", err)
 	}
 
-	bs, err = fsys.cryptor.encrypt(bs)
+	bs, err = encryptContext(ctx, fsys.cryptor, bs)
 	if err != nil {
 		return nil, fmt.Errorf("encryption: %w", err)
 	}
 
+	// Prepend the envelope so it's covered by the MAC
+	if fsys.envelope {
+		bs = append(fsys.newEnvelope().marshal(), bs...)
+	}
+
 	// Prepend the MAC to the encrypted data
 	if len(fsys.hmacKey) > 1 {
 		mac := fsys.computeHMAC(bs)
 		bs = append(mac, bs...)
 	}
 
-	bs, err = fsys.coder.encode(bs)
+	bs, err = fsys.coder.Encode(bs)
 	if err != nil {
 		return nil, fmt.Errorf("encoding: %w", err)
 	}
@@ -165,17 +340,36 @@ func (fsys *FS) computeHMAC(data []byte) []byte {
 }
 
 // WriteFile will attempt to encrypt, encode, and create a file under the given filepath.
-func (fsys *FS) WriteFile(filepath string, plaintext []byte, perm fs.FileMode) error {
-	encodedBytes, err := fsys.Disfigure(plaintext)
+//
+// Files are written atomically. The contents are written to a temporary file in the same
+// directory, synced to disk, and renamed over the target. A failed write never leaves a
+// truncated file behind and the returned error describes which stage failed. As with
+// os.WriteFile a new file is created with perm (before umask) and an existing file
+// keeps its permissions.
+func (fsys *FS) WriteFile(name string, plaintext []byte, perm fs.FileMode) error {
+	return fsys.WriteFileContext(context.Background(), name, plaintext, perm)
+}
+
+// WriteFileContext is like WriteFile but passes ctx to the Cryptor.
+func (fsys *FS) WriteFileContext(ctx context.Context, name string, plaintext []byte, perm fs.FileMode) error {
+	dir, path, err := fsys.localPath("write", name)
+	if err != nil {
+		return err
+	}
+	encodedBytes, err := fsys.DisfigureContext(ctx, plaintext)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(filepath, encodedBytes, perm)
+	err = writeFileAtomic(dir, path, encodedBytes, perm)
 	if err != nil {
-		return fmt.Errorf("writing %s failed: %w", filepath, err)
+		return fmt.Errorf("writing %s failed: %w", name, err)
 	}
 	return nil
 }
 
 var _ fs.FS = (&FS{})
 var _ fs.ReadFileFS = (&FS{})
+var _ fs.StatFS = (&FS{})
+var _ fs.ReadDirFS = (&FS{})
+var _ fs.GlobFS = (&FS{})
+var _ fs.SubFS = (&FS{})
@@ -19,10 +19,16 @@ package cryptfs
 
 import (
 	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+
+	"github.com/moov-io/cryptfs/stream"
 
 	"github.com/stretchr/testify/require"
 )
@@ -168,6 +174,82 @@ func testCryptFS(t *testing.T, fsys *FS) {
 	bs, err = fsys.ReadFile(path)
 	require.NoError(t, err)
 	require.Equal(t, "hello, world", string(bs))
+
+	// Open returns the decrypted contents
+	file, err = fsys.Open(path)
+	require.NoError(t, err)
+
+	info, err := file.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(len("hello, world")), info.Size())
+
+	bs, err = io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(bs))
+	require.NoError(t, file.Close())
+}
+
+func TestCryptfsOpen(t *testing.T) {
+	cc, err := NewAESCryptor([]byte(strings.Repeat("1", 16)))
+	require.NoError(t, err)
+
+	fsys, err := New(cc)
+	require.NoError(t, err)
+	fsys.SetCompression(Gzip())
+
+	parent := t.TempDir()
+	path := filepath.Join(parent, "data.txt")
+	plaintext := []byte(strings.Repeat("hello, world ", 100))
+
+	err = fsys.WriteFile(path, plaintext, 0600)
+	require.NoError(t, err)
+
+	t.Run("read and seek", func(t *testing.T) {
+		file, err := fsys.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+
+		seeker, ok := file.(io.ReadSeeker)
+		require.True(t, ok)
+
+		_, err = seeker.Seek(7, io.SeekStart)
+		require.NoError(t, err)
+
+		bs := make([]byte, 5)
+		_, err = io.ReadFull(seeker, bs)
+		require.NoError(t, err)
+		require.Equal(t, "world", string(bs))
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		file, err := fsys.Open(parent)
+		require.NoError(t, err)
+		defer file.Close()
+
+		info, err := file.Stat()
+		require.NoError(t, err)
+		require.True(t, info.IsDir())
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		file, err := fsys.Open(path)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		_, err = file.Read(make([]byte, 10))
+		require.ErrorIs(t, err, fs.ErrClosed)
+		require.ErrorIs(t, file.Close(), fs.ErrClosed)
+	})
+
+	t.Run("invalid ciphertext", func(t *testing.T) {
+		other := filepath.Join(parent, "other.txt")
+		err := os.WriteFile(other, []byte("not encrypted"), 0600)
+		require.NoError(t, err)
+
+		file, err := fsys.Open(other)
+		require.Error(t, err)
+		require.Nil(t, file)
+	})
 }
 
 func TestCryptfsError(t *testing.T) {
@@ -189,3 +271,216 @@ func TestCryptfsError(t *testing.T) {
 	err = filesys.WriteFile(badPath, []byte("data"), 0600)
 	require.ErrorIs(t, err, os.ErrNotExist)
 }
+
+func TestCryptfsFSInterfaces(t *testing.T) {
+	key := []byte(strings.Repeat("1", 16))
+	cc, err := NewAESCryptor(key)
+	require.NoError(t, err)
+	kp := stream.NewStaticKeyProvider(key)
+
+	// Streams record their plaintext size, so every size is known without decrypting
+	dir := t.TempDir()
+	fsys, err := NewWithRoot(dir, cc)
+	require.NoError(t, err)
+	fsys.SetCompression(Gzip())
+	fsys.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+	fsys.SetKeyProvider(kp)
+	fsys.SetStreamFormat(stream.WithCompression())
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0700))
+	require.NoError(t, fsys.WriteFile("hello.txt", []byte("hello, world"), 0600))
+	require.NoError(t, fsys.WriteFile("a/one.txt", []byte("one"), 0600))
+	require.NoError(t, fsys.WriteFile("a/b/two.txt", []byte(strings.Repeat("two", 100)), 0600))
+
+	t.Run("fstest", func(t *testing.T) {
+		err := fstest.TestFS(fsys, "hello.txt", "a/one.txt", "a/b/two.txt")
+		require.NoError(t, err)
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		info, err := fsys.Stat("a/b/two.txt")
+		require.NoError(t, err)
+		require.Equal(t, int64(300), info.Size())
+		require.Equal(t, "two.txt", info.Name())
+
+		info, err = fsys.Stat("a")
+		require.NoError(t, err)
+		require.True(t, info.IsDir())
+
+		_, err = fsys.Stat("missing.txt")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("ReadDir", func(t *testing.T) {
+		entries, err := fsys.ReadDir("a")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		require.Equal(t, "b", entries[0].Name())
+		require.True(t, entries[0].IsDir())
+
+		require.Equal(t, "one.txt", entries[1].Name())
+		info, err := entries[1].Info()
+		require.NoError(t, err)
+		require.Equal(t, int64(3), info.Size())
+	})
+
+	t.Run("WalkDir", func(t *testing.T) {
+		sizes := make(map[string]int64)
+		err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			sizes[path] = info.Size()
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{
+			"hello.txt":   12,
+			"a/one.txt":   3,
+			"a/b/two.txt": 300,
+		}, sizes)
+	})
+
+	t.Run("Glob", func(t *testing.T) {
+		matches, err := fsys.Glob("a/*.txt")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a/one.txt"}, matches)
+	})
+
+	t.Run("Sub", func(t *testing.T) {
+		sub, err := fsys.Sub("a")
+		require.NoError(t, err)
+
+		subfs, ok := sub.(*FS)
+		require.True(t, ok)
+
+		bs, err := subfs.ReadFile("b/two.txt")
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("two", 100), string(bs))
+
+		// Writes go beneath the sub directory
+		require.NoError(t, subfs.WriteFile("three.txt", []byte("three"), 0600))
+		bs, err = fsys.ReadFile("a/three.txt")
+		require.NoError(t, err)
+		require.Equal(t, "three", string(bs))
+
+		_, err = fsys.Sub("../a")
+		require.ErrorIs(t, err, fs.ErrInvalid)
+	})
+
+	t.Run("local paths", func(t *testing.T) {
+		local, err := New(cc)
+		require.NoError(t, err)
+		local.SetCompression(Gzip())
+		local.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+		local.SetKeyProvider(kp)
+		local.SetStreamFormat(stream.WithCompression())
+
+		info, err := local.Stat(filepath.Join(dir, "hello.txt"))
+		require.NoError(t, err)
+		require.Equal(t, int64(12), info.Size())
+
+		entries, err := local.ReadDir(filepath.Join(dir, "a"))
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+
+		sub, err := local.Sub(filepath.Join(dir, "a"))
+		require.NoError(t, err)
+		bs, err := fs.ReadFile(sub, "one.txt")
+		require.NoError(t, err)
+		require.Equal(t, "one", string(bs))
+	})
+
+	t.Run("sizes on disk", func(t *testing.T) {
+		legacy, err := NewWithRoot(t.TempDir(), cc)
+		require.NoError(t, err)
+		legacy.SetCompression(Gzip())
+		require.NoError(t, legacy.WriteFile("hello.txt", []byte("hello, world"), 0600))
+
+		// Other formats aren't decrypted by Stat, so the size on disk is reported
+		info, err := legacy.Stat("hello.txt")
+		require.NoError(t, err)
+		raw, err := legacy.statRaw("hello.txt")
+		require.NoError(t, err)
+		require.Equal(t, raw.Size(), info.Size())
+
+		// Opened files have been decrypted and know their size
+		file, err := legacy.Open("hello.txt")
+		require.NoError(t, err)
+		info, err = file.Stat()
+		require.NoError(t, err)
+		require.Equal(t, int64(12), info.Size())
+		require.NoError(t, file.Close())
+	})
+}
+
+type ctxKey struct{}
+
+// contextCryptor wraps a Cryptor and records the context values it is called with.
+type contextCryptor struct {
+	Cryptor
+
+	seen []any
+}
+
+func (c *contextCryptor) EncryptContext(ctx context.Context, data []byte) ([]byte, error) {
+	c.seen = append(c.seen, ctx.Value(ctxKey{}))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Encrypt(data)
+}
+
+func (c *contextCryptor) DecryptContext(ctx context.Context, data []byte) ([]byte, error) {
+	c.seen = append(c.seen, ctx.Value(ctxKey{}))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Decrypt(data)
+}
+
+func TestCryptfsContext(t *testing.T) {
+	aes, err := NewAESCryptor([]byte(strings.Repeat("1", 16)))
+	require.NoError(t, err)
+
+	cc := &contextCryptor{Cryptor: aes}
+	fsys, err := New(cc)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request-1")
+	path := filepath.Join(t.TempDir(), "data.txt")
+
+	err = fsys.WriteFileContext(ctx, path, []byte("hello, world"), 0600)
+	require.NoError(t, err)
+
+	bs, err := fsys.ReadFileContext(ctx, path)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(bs))
+	require.Equal(t, []any{"request-1", "request-1"}, cc.seen)
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := fsys.DisfigureContext(ctx, []byte("hello"))
+		require.ErrorIs(t, err, context.Canceled)
+
+		_, err = fsys.ReadFileContext(ctx, path)
+		require.ErrorIs(t, err, context.Canceled)
+
+		// Cryptors without context support still observe cancellation
+		plain, err := New(aes)
+		require.NoError(t, err)
+
+		encrypted, err := plain.Disfigure([]byte("hello"))
+		require.NoError(t, err)
+
+		_, err = plain.RevealContext(ctx, encrypted)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -17,21 +17,58 @@
 
 package cryptfs
 
+import (
+	"context"
+)
+
+// Cryptor is an interface describing two operations which encrypt and decrypt data.
+// Implementations outside of this package can be used with New and FromCryptor.
 type Cryptor interface {
-	encrypt(data []byte) ([]byte, error)
-	decrypt(data []byte) ([]byte, error)
+	Encrypt(data []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// ContextCryptor is implemented by a Cryptor which can honor cancellation and
+// deadlines, such as one making network calls. FS methods ending in Context
+// use these methods when they're available.
+type ContextCryptor interface {
+	Cryptor
+
+	EncryptContext(ctx context.Context, data []byte) ([]byte, error)
+	DecryptContext(ctx context.Context, data []byte) ([]byte, error)
+}
+
+func encryptContext(ctx context.Context, c Cryptor, data []byte) ([]byte, error) {
+	if cc, ok := c.(ContextCryptor); ok {
+		return cc.EncryptContext(ctx, data)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Encrypt(data)
+}
+
+func decryptContext(ctx context.Context, c Cryptor, data []byte) ([]byte, error) {
+	if cc, ok := c.(ContextCryptor); ok {
+		return cc.DecryptContext(ctx, data)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Decrypt(data)
 }
 
+// NoEncryption is a Cryptor which does not transform data.
 func NoEncryption() Cryptor {
 	return &nothingCryptor{}
 }
 
 type nothingCryptor struct{}
 
-func (*nothingCryptor) encrypt(data []byte) ([]byte, error) {
+func (*nothingCryptor) Encrypt(data []byte) ([]byte, error) {
 	return data, nil
 }
 
-func (*nothingCryptor) decrypt(data []byte) ([]byte, error) {
+func (*nothingCryptor) Decrypt(data []byte) ([]byte, error) {
 	return data, nil
 }
@@ -41,7 +41,8 @@ func NewAESCryptor(key []byte) (*AESCryptor, error) {
 	return &AESCryptor{cphr: cphr}, nil
 }
 
-func (c *AESCryptor) encrypt(data []byte) ([]byte, error) {
+// Encrypt seals data with AES-GCM and prepends the random nonce.
+func (c *AESCryptor) Encrypt(data []byte) ([]byte, error) {
 	gcm, err := cipher.NewGCM(c.cphr)
 	if err != nil {
 		return nil, err
@@ -54,7 +55,8 @@ func (c *AESCryptor) encrypt(data []byte) ([]byte, error) {
 	return out, nil
 }
 
-func (c *AESCryptor) decrypt(ciphertext []byte) ([]byte, error) {
+// Decrypt opens ciphertext produced by Encrypt.
+func (c *AESCryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 	gcm, err := cipher.NewGCM(c.cphr)
 	if err != nil {
 		return nil, err
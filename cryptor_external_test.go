@@ -0,0 +1,122 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/moov-io/cryptfs"
+
+	"github.com/stretchr/testify/require"
+)
+
+// xorCryptor, reverseCompressor and hexCoder are implemented outside of the
+// cryptfs package to verify third-party implementations can be plugged in.
+
+type xorCryptor struct {
+	key byte
+}
+
+func (c xorCryptor) Encrypt(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] ^ c.key
+	}
+	return out, nil
+}
+
+func (c xorCryptor) Decrypt(data []byte) ([]byte, error) {
+	return c.Encrypt(data)
+}
+
+type reverseCompressor struct{}
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) {
+	out := bytes.Clone(data)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+func (c reverseCompressor) Decompress(data []byte) ([]byte, error) {
+	return c.Compress(data)
+}
+
+type hexCoder struct{}
+
+func (hexCoder) Encode(data []byte) ([]byte, error) {
+	return []byte(hex.EncodeToString(data)), nil
+}
+
+func (hexCoder) Decode(data []byte) ([]byte, error) {
+	return hex.DecodeString(string(data))
+}
+
+func TestExternalImplementations(t *testing.T) {
+	fsys, err := cryptfs.New(xorCryptor{key: 0x5a})
+	require.NoError(t, err)
+	fsys.SetCompression(reverseCompressor{})
+	fsys.SetCoder(hexCoder{})
+
+	encrypted, err := fsys.Disfigure([]byte("hello, world"))
+	require.NoError(t, err)
+
+	expected, _ := xorCryptor{key: 0x5a}.Encrypt([]byte("dlrow ,olleh"))
+	require.Equal(t, hex.EncodeToString(expected), string(encrypted))
+
+	plaintext, err := fsys.Reveal(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(plaintext))
+
+	path := filepath.Join(t.TempDir(), "data.txt")
+	require.NoError(t, fsys.WriteFile(path, []byte("hello, world"), 0600))
+
+	plaintext, err = fsys.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(plaintext))
+}
+
+// Built-in implementations can be used directly by other packages.
+func TestExportedMethods(t *testing.T) {
+	cc, err := cryptfs.NewAESCryptor([]byte("1234567812345678"))
+	require.NoError(t, err)
+
+	var cryptor cryptfs.Cryptor = cc
+	encrypted, err := cryptor.Encrypt([]byte("hello"))
+	require.NoError(t, err)
+
+	decrypted, err := cryptor.Decrypt(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(decrypted))
+
+	compressed, err := cryptfs.Gzip().Compress([]byte("hello"))
+	require.NoError(t, err)
+	decompressed, err := cryptfs.Gzip().Decompress(compressed)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(decompressed))
+
+	encoded, err := cryptfs.Base64().Encode([]byte("hello"))
+	require.NoError(t, err)
+	decoded, err := cryptfs.Base64().Decode(encoded)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(decoded))
+}
@@ -105,7 +105,8 @@ func NewGPGCryptorFile(publicKeyPath, privateKeyPath string, password []byte) (C
 	}, nil
 }
 
-func (c *GPGCryptor) encrypt(data []byte) ([]byte, error) {
+// Encrypt signs data (when private keys are present) and encrypts it to the public keys.
+func (c *GPGCryptor) Encrypt(data []byte) ([]byte, error) {
 	if len(c.publicKeys) == 0 {
 		return nil, errors.New("gpg: missing public keys")
 	}
@@ -130,7 +131,8 @@ func (c *GPGCryptor) encrypt(data []byte) ([]byte, error) {
 	return encryptedData, nil
 }
 
-func (c *GPGCryptor) decrypt(data []byte) ([]byte, error) {
+// Decrypt decrypts data with the private keys and verifies any signature against the public keys.
+func (c *GPGCryptor) Decrypt(data []byte) ([]byte, error) {
 	if len(c.privateKeys) == 0 {
 		return nil, errors.New("gpg: missing private keys")
 	}
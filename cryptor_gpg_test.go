@@ -32,14 +32,14 @@ func TestCryptorGPG(t *testing.T) {
 	dd, err := NewGPGDecryptorFile(filepath.Join("internal", "gpgx", "testdata", "key.priv"), []byte("password"))
 	require.NoError(t, err)
 
-	enc, err := ee.encrypt([]byte("hello, world"))
+	enc, err := ee.Encrypt([]byte("hello, world"))
 	require.NoError(t, err)
 
-	dec1, err := dd.decrypt(enc)
+	dec1, err := dd.Decrypt(enc)
 	require.NoError(t, err)
 	require.Equal(t, "hello, world", string(dec1))
 
-	dec2, err := dd.decrypt(enc)
+	dec2, err := dd.Decrypt(enc)
 	require.NoError(t, err)
 	require.Equal(t, "hello, world", string(dec2))
 }
@@ -66,7 +66,7 @@ func TestCryptorGPGError(t *testing.T) {
 	require.Nil(t, ee)
 
 	ee = &GPGCryptor{}
-	bs, err := ee.encrypt([]byte("hello, world"))
+	bs, err := ee.Encrypt([]byte("hello, world"))
 	require.Error(t, err)
 	require.Len(t, bs, 0)
 
@@ -75,7 +75,7 @@ func TestCryptorGPGError(t *testing.T) {
 	require.Nil(t, dd)
 
 	dd = &GPGCryptor{}
-	bs, err = dd.decrypt([]byte("hello, world"))
+	bs, err = dd.Decrypt([]byte("hello, world"))
 	require.Error(t, err)
 	require.Len(t, bs, 0)
 }
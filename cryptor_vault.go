@@ -18,6 +18,7 @@
 package cryptfs
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -119,15 +120,23 @@ type VaultCryptor struct {
 	*vaultClient
 }
 
-func (v *VaultCryptor) encrypt(plaintext []byte) ([]byte, error) {
+var _ ContextCryptor = (&VaultCryptor{})
+
+// Encrypt sends plaintext to the Vault transit engine for encryption.
+func (v *VaultCryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	return v.EncryptContext(context.Background(), plaintext)
+}
+
+// EncryptContext is like Encrypt but the request to Vault is canceled along with ctx.
+func (v *VaultCryptor) EncryptContext(ctx context.Context, plaintext []byte) ([]byte, error) {
 	if err := v.auth(); err != nil {
 		return nil, err
 	}
 
 	params := map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}
-	res, err := v.client.Logical().Write(fmt.Sprintf("/transit/encrypt/%s", v.config.KeyName), params)
+	res, err := v.client.Logical().WriteWithContext(ctx, fmt.Sprintf("/transit/encrypt/%s", v.config.KeyName), params)
 	if err != nil {
-		return nil, fmt.Errorf("encrypting data: %v", err)
+		return nil, fmt.Errorf("encrypting data: %w", err)
 	}
 
 	data := res.Data["ciphertext"]
@@ -138,15 +147,21 @@ func (v *VaultCryptor) encrypt(plaintext []byte) ([]byte, error) {
 	return []byte(ciphertext), nil
 }
 
-func (v *VaultCryptor) decrypt(ciphertext []byte) ([]byte, error) {
+// Decrypt sends ciphertext to the Vault transit engine for decryption.
+func (v *VaultCryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	return v.DecryptContext(context.Background(), ciphertext)
+}
+
+// DecryptContext is like Decrypt but the request to Vault is canceled along with ctx.
+func (v *VaultCryptor) DecryptContext(ctx context.Context, ciphertext []byte) ([]byte, error) {
 	if err := v.auth(); err != nil {
 		return nil, err
 	}
 
 	params := map[string]interface{}{"ciphertext": string(ciphertext)}
-	res, err := v.client.Logical().Write(fmt.Sprintf("/transit/decrypt/%s", v.config.KeyName), params)
+	res, err := v.client.Logical().WriteWithContext(ctx, fmt.Sprintf("/transit/decrypt/%s", v.config.KeyName), params)
 	if err != nil {
-		return nil, fmt.Errorf("decrypting data: %v", err)
+		return nil, fmt.Errorf("decrypting data: %w", err)
 	}
 
 	data := res.Data["plaintext"]
@@ -157,7 +172,7 @@ func (v *VaultCryptor) decrypt(ciphertext []byte) ([]byte, error) {
 
 	plaintext, err := base64.StdEncoding.DecodeString(base64Plaintext)
 	if err != nil {
-		return nil, fmt.Errorf("decoding plaintext: %v", err)
+		return nil, fmt.Errorf("decoding plaintext: %w", err)
 	}
 	return plaintext, nil
 }
@@ -19,6 +19,7 @@ package cryptfs
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
@@ -70,6 +71,14 @@ func TestVaultCryptor(t *testing.T) {
 		require.Equal(t, input, bs)
 	})
 
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := fsys.DisfigureContext(ctx, []byte("hello, world"))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
 	t.Run("with HMAC key", func(t *testing.T) {
 		fsys.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
 
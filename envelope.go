@@ -0,0 +1,197 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// envelopeMagic marks data produced by Disfigure with the envelope enabled.
+var envelopeMagic = [4]byte{'C', 'R', 'F', 'E'}
+
+const (
+	envelopeVersion = 0x01
+
+	// envelopeFixedSize is magic(4) + version(1) + cryptor(1) + compression(1) + keyIDLen(1) = 8
+	envelopeFixedSize = 8
+)
+
+// Algorithm identifiers recorded in the envelope.
+const (
+	envelopeNone  byte = 0x00
+	envelopeAES   byte = 0x01
+	envelopeGPG   byte = 0x02
+	envelopeVault byte = 0x03
+	envelopeGzip  byte = 0x01
+
+	// envelopeOther is recorded for implementations outside of this package.
+	envelopeOther byte = 0xFF
+)
+
+// envelope is a versioned header which describes how the data following it was produced.
+//
+// Layout: magic(4) | version(1) | cryptor(1) | compression(1) | keyIDLen(1) | keyID
+type envelope struct {
+	Cryptor     byte
+	Compression byte
+	KeyID       string
+}
+
+func (e *envelope) marshal() []byte {
+	bs := make([]byte, envelopeFixedSize+len(e.KeyID))
+	copy(bs[0:4], envelopeMagic[:])
+	bs[4] = envelopeVersion
+	bs[5] = e.Cryptor
+	bs[6] = e.Compression
+	bs[7] = byte(len(e.KeyID))
+	copy(bs[envelopeFixedSize:], e.KeyID)
+	return bs
+}
+
+// hasEnvelope reports if data begins with an envelope header. Data without one is legacy.
+func hasEnvelope(data []byte) bool {
+	return len(data) > 4 && bytes.Equal(data[0:4], envelopeMagic[:]) && data[4] == envelopeVersion
+}
+
+// parseEnvelope reads the envelope header and returns the remaining data.
+func parseEnvelope(data []byte) (*envelope, []byte, error) {
+	if len(data) < envelopeFixedSize {
+		return nil, nil, errors.New("envelope header too short")
+	}
+	if !hasEnvelope(data) {
+		return nil, nil, errors.New("invalid envelope header")
+	}
+	keyIDLen := int(data[7])
+	if len(data) < envelopeFixedSize+keyIDLen {
+		return nil, nil, errors.New("envelope key ID truncated")
+	}
+	e := &envelope{
+		Cryptor:     data[5],
+		Compression: data[6],
+		KeyID:       string(data[envelopeFixedSize : envelopeFixedSize+keyIDLen]),
+	}
+	return e, data[envelopeFixedSize+keyIDLen:], nil
+}
+
+func cryptorID(c Cryptor) byte {
+	switch c.(type) {
+	case *nothingCryptor:
+		return envelopeNone
+	case *AESCryptor:
+		return envelopeAES
+	case *GPGCryptor:
+		return envelopeGPG
+	case *VaultCryptor:
+		return envelopeVault
+	}
+	return envelopeOther
+}
+
+func compressorID(c Compressor) byte {
+	switch c.(type) {
+	case *nothingCompressor:
+		return envelopeNone
+	case *gzipCompressor:
+		return envelopeGzip
+	}
+	return envelopeOther
+}
+
+// SetEnvelope enables or disables writing a versioned envelope header from Disfigure
+// and WriteFile. The envelope records which Cryptor, compression and key ID produced
+// the data so Reveal can choose how to read it.
+//
+// The envelope is only authenticated by the MAC from SetHMACKey. Without an HMAC key
+// Reveal rejects envelopes whose compression differs from the configured Compressor,
+// so flipping it can't make Reveal return compressed bytes as plaintext.
+//
+// Reveal accepts data with or without an envelope regardless of this setting.
+func (fsys *FS) SetEnvelope(enabled bool) {
+	if fsys != nil {
+		fsys.envelope = enabled
+	}
+}
+
+// SetKeyID sets the key ID recorded in the envelope of data written by Disfigure.
+// Key IDs are limited to 255 bytes.
+func (fsys *FS) SetKeyID(keyID string) error {
+	if len(keyID) > 255 {
+		return fmt.Errorf("key ID is %d bytes, limit is 255", len(keyID))
+	}
+	if fsys != nil {
+		fsys.keyID = keyID
+	}
+	return nil
+}
+
+// AddCryptor registers a Cryptor which Reveal uses for envelopes with the given key ID.
+// This allows data written with previous keys to be read during a migration.
+func (fsys *FS) AddCryptor(keyID string, cryptor Cryptor) {
+	if fsys == nil || cryptor == nil {
+		return
+	}
+	if fsys.cryptors == nil {
+		fsys.cryptors = make(map[string]Cryptor)
+	}
+	fsys.cryptors[keyID] = cryptor
+}
+
+func (fsys *FS) newEnvelope() *envelope {
+	return &envelope{
+		Cryptor:     cryptorID(fsys.cryptor),
+		Compression: compressorID(fsys.compressor),
+		KeyID:       fsys.keyID,
+	}
+}
+
+// envelopeCryptor returns the Cryptor for decrypting data with the envelope.
+func (fsys *FS) envelopeCryptor(e *envelope) (Cryptor, error) {
+	cryptor, found := fsys.cryptors[e.KeyID]
+	if !found {
+		if e.KeyID != fsys.keyID {
+			return nil, fmt.Errorf("no cryptor for key ID %q", e.KeyID)
+		}
+		cryptor = fsys.cryptor
+	}
+	if id := cryptorID(cryptor); id != e.Cryptor {
+		return nil, fmt.Errorf("envelope requires cryptor 0x%02x but found 0x%02x", e.Cryptor, id)
+	}
+	return cryptor, nil
+}
+
+// envelopeCompressor returns the Compressor for decompressing data with the envelope.
+// Unless the envelope was authenticated by the MAC it must match the Compressor of fsys.
+func (fsys *FS) envelopeCompressor(e *envelope, authenticated bool) (Compressor, error) {
+	if id := compressorID(fsys.compressor); !authenticated && id != e.Compression {
+		return nil, fmt.Errorf("envelope requires compression 0x%02x but found 0x%02x, which requires an HMAC key", e.Compression, id)
+	}
+	switch e.Compression {
+	case envelopeNone:
+		return NoCompression(), nil
+	case envelopeGzip:
+		if compressorID(fsys.compressor) == envelopeGzip {
+			return fsys.compressor, nil
+		}
+		return Gzip(), nil
+	case envelopeOther:
+		return fsys.compressor, nil
+	}
+	return nil, fmt.Errorf("unknown envelope compression 0x%02x", e.Compression)
+}
@@ -0,0 +1,195 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	oldKey, err := NewAESCryptor([]byte(strings.Repeat("1", 16)))
+	require.NoError(t, err)
+	newKey, err := NewAESCryptor([]byte(strings.Repeat("2", 16)))
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		fsys, err := New(oldKey)
+		require.NoError(t, err)
+		fsys.SetEnvelope(true)
+		fsys.SetCompression(Gzip())
+		fsys.SetCoder(Base64())
+		fsys.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+		require.NoError(t, fsys.SetKeyID("key-1"))
+
+		testCryptFS(t, fsys)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		fsys, err := New(oldKey)
+		require.NoError(t, err)
+		fsys.SetEnvelope(true)
+		fsys.SetCompression(Gzip())
+		require.NoError(t, fsys.SetKeyID("key-1"))
+
+		bs, err := fsys.Disfigure([]byte("hello, world"))
+		require.NoError(t, err)
+		require.True(t, hasEnvelope(bs))
+
+		env, rest, err := parseEnvelope(bs)
+		require.NoError(t, err)
+		require.Equal(t, envelopeAES, env.Cryptor)
+		require.Equal(t, envelopeGzip, env.Compression)
+		require.Equal(t, "key-1", env.KeyID)
+		require.Len(t, rest, len(bs)-envelopeFixedSize-len("key-1"))
+	})
+
+	t.Run("reader uses envelope compression", func(t *testing.T) {
+		writer, err := New(oldKey)
+		require.NoError(t, err)
+		writer.SetEnvelope(true)
+		writer.SetCompression(GzipLevel(9))
+		writer.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+
+		bs, err := writer.Disfigure([]byte(strings.Repeat("hello, world", 100)))
+		require.NoError(t, err)
+
+		// No compression configured on the reader
+		reader, err := New(oldKey)
+		require.NoError(t, err)
+		reader.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+
+		plaintext, err := reader.Reveal(bs)
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("hello, world", 100), string(plaintext))
+	})
+
+	t.Run("unauthenticated compression", func(t *testing.T) {
+		fsys, err := New(oldKey)
+		require.NoError(t, err)
+		fsys.SetEnvelope(true)
+		fsys.SetCompression(Gzip())
+
+		bs, err := fsys.Disfigure([]byte("hello, world"))
+		require.NoError(t, err)
+
+		plaintext, err := fsys.Reveal(bs)
+		require.NoError(t, err)
+		require.Equal(t, "hello, world", string(plaintext))
+
+		// Without a MAC the compression byte isn't authenticated, so it must match
+		bs[6] = envelopeNone
+		_, err = fsys.Reveal(bs)
+		require.ErrorContains(t, err, "envelope requires compression 0x00 but found 0x01")
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		writer, err := New(oldKey)
+		require.NoError(t, err)
+		writer.SetEnvelope(true)
+		require.NoError(t, writer.SetKeyID("2023"))
+
+		oldData, err := writer.Disfigure([]byte("old data"))
+		require.NoError(t, err)
+
+		reader, err := New(newKey)
+		require.NoError(t, err)
+		reader.SetEnvelope(true)
+		require.NoError(t, reader.SetKeyID("2024"))
+
+		newData, err := reader.Disfigure([]byte("new data"))
+		require.NoError(t, err)
+
+		// Without the old key registered
+		_, err = reader.Reveal(oldData)
+		require.ErrorContains(t, err, `no cryptor for key ID "2023"`)
+
+		reader.AddCryptor("2023", oldKey)
+
+		plaintext, err := reader.Reveal(oldData)
+		require.NoError(t, err)
+		require.Equal(t, "old data", string(plaintext))
+
+		plaintext, err = reader.Reveal(newData)
+		require.NoError(t, err)
+		require.Equal(t, "new data", string(plaintext))
+	})
+
+	t.Run("legacy data", func(t *testing.T) {
+		legacy, err := New(oldKey)
+		require.NoError(t, err)
+		legacy.SetCoder(Base64())
+
+		bs, err := legacy.Disfigure([]byte("hello, world"))
+		require.NoError(t, err)
+
+		fsys, err := New(oldKey)
+		require.NoError(t, err)
+		fsys.SetEnvelope(true)
+		fsys.SetCoder(Base64())
+
+		plaintext, err := fsys.Reveal(bs)
+		require.NoError(t, err)
+		require.Equal(t, "hello, world", string(plaintext))
+	})
+
+	t.Run("cryptor mismatch", func(t *testing.T) {
+		writer, err := New(NoEncryption())
+		require.NoError(t, err)
+		writer.SetEnvelope(true)
+
+		bs, err := writer.Disfigure([]byte("hello, world"))
+		require.NoError(t, err)
+
+		reader, err := New(oldKey)
+		require.NoError(t, err)
+
+		_, err = reader.Reveal(bs)
+		require.ErrorContains(t, err, "envelope requires cryptor 0x00 but found 0x01")
+	})
+
+	t.Run("tampered header", func(t *testing.T) {
+		fsys, err := New(oldKey)
+		require.NoError(t, err)
+		fsys.SetEnvelope(true)
+		fsys.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+
+		bs, err := fsys.Disfigure([]byte("hello, world"))
+		require.NoError(t, err)
+
+		// Change the compression ID, which is covered by the MAC
+		bs[32+6] = envelopeGzip
+
+		_, err = fsys.Reveal(bs)
+		require.ErrorContains(t, err, "invalid MAC")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, _, err := parseEnvelope([]byte("CRFE"))
+		require.ErrorContains(t, err, "too short")
+
+		_, _, err = parseEnvelope([]byte("CRFE\x01\x01\x00\x05ab"))
+		require.ErrorContains(t, err, "key ID truncated")
+
+		fsys, err := New(oldKey)
+		require.NoError(t, err)
+		require.Error(t, fsys.SetKeyID(strings.Repeat("a", 256)))
+	})
+}
@@ -0,0 +1,169 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// file is a read-only fs.File which serves the decrypted contents of a file.
+type file struct {
+	info   fs.FileInfo
+	r      *bytes.Reader
+	closed bool
+}
+
+func newFile(info fs.FileInfo, plaintext []byte) *file {
+	return &file{
+		info: &fileInfo{
+			FileInfo: info,
+			size:     int64(len(plaintext)),
+		},
+		r: bytes.NewReader(plaintext),
+	}
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	return f.r.Read(p)
+}
+
+// Seek allows the file to be used where an io.ReadSeeker is expected, such as http.FS.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// fileInfo reports the plaintext size of a file rather than the size stored on disk
+// when it's known.
+type fileInfo struct {
+	fs.FileInfo
+
+	size int64
+
+	// sizeFn is called once to determine the plaintext size. When it isn't ok
+	// the size stored on disk is reported instead.
+	sizeFn func() (int64, bool)
+	once   sync.Once
+}
+
+func (fi *fileInfo) Size() int64 {
+	fi.once.Do(func() {
+		if fi.sizeFn == nil {
+			return
+		}
+		if n, ok := fi.sizeFn(); ok {
+			fi.size = n
+		}
+	})
+	return fi.size
+}
+
+// plaintextInfo wraps info so Size reports the plaintext size of the file at name
+// when it's recorded in a CRFS stream's trailer, which is only read if Size is called.
+// Other files aren't decrypted, so their size on disk is reported.
+func (fsys *FS) plaintextInfo(name string, info fs.FileInfo) fs.FileInfo {
+	if info.IsDir() || !info.Mode().IsRegular() {
+		return info
+	}
+	return &fileInfo{
+		FileInfo: info,
+		size:     info.Size(),
+		sizeFn: func() (int64, bool) {
+			return fsys.streamSize(name)
+		},
+	}
+}
+
+// dir is an fs.ReadDirFile whose entries report plaintext sizes when known.
+type dir struct {
+	fs.ReadDirFile
+
+	fsys *FS
+	name string
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.ReadDirFile.Stat()
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.ReadDirFile.ReadDir(n)
+	return d.fsys.wrapDirEntries(d.name, entries), err
+}
+
+// dirEntry is an fs.DirEntry whose Info reports the plaintext size when known.
+type dirEntry struct {
+	fs.DirEntry
+
+	fsys *FS
+	name string
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return e.fsys.plaintextInfo(e.name, info), nil
+}
+
+func (fsys *FS) wrapDirEntries(name string, entries []fs.DirEntry) []fs.DirEntry {
+	for i := range entries {
+		entries[i] = &dirEntry{
+			DirEntry: entries[i],
+			fsys:     fsys,
+			name:     fsys.join(name, entries[i].Name()),
+		}
+	}
+	return entries
+}
+
+var _ fs.File = (&file{})
+var _ io.ReadSeeker = (&file{})
+var _ io.ReaderAt = (&file{})
+var _ fs.ReadDirFile = (&dir{})
@@ -57,25 +57,36 @@ func decryptPrivateKey(entityList openpgp.EntityList, password []byte) (openpgp.
 		return nil, errors.New("gpg: no entities found")
 	}
 
-	entity := entityList[0]
+	// Get the passphrase and read the private keys. Entities of the keyring which
+	// the passphrase doesn't decrypt are skipped as long as one of them decrypts.
+	var errs []error
+	for _, entity := range entityList {
+		if err := decryptEntity(entity, password); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(entityList) {
+		return nil, errs[0]
+	}
+	return entityList, nil
+}
 
-	// Get the passphrase and read the private key.
+func decryptEntity(entity *openpgp.Entity, password []byte) error {
 	if entity.PrivateKey != nil && len(password) > 0 {
 		err := entity.PrivateKey.Decrypt(password)
 		if err != nil {
-			return nil, fmt.Errorf("decrypting private key failed: %w", err)
+			return fmt.Errorf("decrypting private key failed: %w", err)
 		}
 	}
 	for idx, subkey := range entity.Subkeys {
 		if subkey.PrivateKey != nil && len(password) > 0 {
 			err := subkey.PrivateKey.Decrypt(password)
 			if err != nil {
-				return nil, fmt.Errorf("decrypting subkey %d failed: %w", idx, err)
+				return fmt.Errorf("decrypting subkey %d failed: %w", idx, err)
 			}
 		}
 	}
-
-	return entityList, nil
+	return nil
 }
 
 func Encrypt(msg []byte, pubkeys openpgp.EntityList) ([]byte, error) {
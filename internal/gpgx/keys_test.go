@@ -5,11 +5,15 @@
 package gpgx
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,6 +51,35 @@ func TestGPG(t *testing.T) {
 	require.Equal(t, "hello, world", string(out))
 }
 
+func TestGPG__keyring(t *testing.T) {
+	conf := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	newEntity := func(passphrase string) *openpgp.Entity {
+		entity, err := openpgp.NewEntity("Test", "", "test@example.com", conf)
+		require.NoError(t, err)
+		require.NoError(t, entity.EncryptPrivateKeys([]byte(passphrase), conf))
+		return entity
+	}
+	first, second := newEntity("password"), newEntity("other")
+
+	// Entities of a keyring can have different passphrases
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, first.SerializePrivateWithoutSigning(w, conf))
+	require.NoError(t, second.SerializePrivateWithoutSigning(w, conf))
+	require.NoError(t, w.Close())
+	keyring := buf.Bytes()
+
+	for _, pass := range []string{"password", "other"} {
+		privKey, err := ReadPrivateKey(bytes.NewReader(keyring), []byte(pass))
+		require.NoError(t, err)
+		require.Len(t, privKey, 2)
+	}
+
+	_, err = ReadPrivateKey(bytes.NewReader(keyring), []byte("invalid"))
+	require.ErrorContains(t, err, "decrypting private key failed")
+}
+
 func TestGPGError(t *testing.T) {
 	el, err := ReadArmoredKey(strings.NewReader("invalid"))
 	require.Error(t, err)
@@ -0,0 +1,131 @@
+package cryptfs
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/moov-io/cryptfs/internal/gpgx"
+	"github.com/moov-io/cryptfs/stream"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// gpgDataKeySize is the size of the AES-256 data keys encrypted to the public keys
+const gpgDataKeySize = 32
+
+type gpgKeyProvider struct {
+	publicKeys  openpgp.EntityList
+	privateKeys openpgp.EntityList
+}
+
+// NewGPGKeyProvider returns a stream.KeyProvider which generates a random data key for
+// each stream and wraps it in an OpenPGP message encrypted to every key in publicKey.
+// Streams are read by unwrapping the data key with any of the keys in privateKey, whose
+// keys are all decrypted with password. Only the data key is encrypted with OpenPGP.
+//
+// Either reader may be nil: a provider without public keys only reads streams, and one
+// without a private key only writes them. A write-only provider for each partner can be
+// passed to stream.WithRecipients.
+func NewGPGKeyProvider(publicKey, privateKey io.Reader, password []byte) (stream.KeyProvider, error) {
+	p := &gpgKeyProvider{}
+	var err error
+	if publicKey != nil {
+		p.publicKeys, err = gpgx.ReadArmoredKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if privateKey != nil {
+		p.privateKeys, err = gpgx.ReadPrivateKey(privateKey, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// NewGPGKeyProviderFile is like NewGPGKeyProvider but reads the keys from files. Either
+// path may be empty.
+func NewGPGKeyProviderFile(publicKeyPath, privateKeyPath string, password []byte) (stream.KeyProvider, error) {
+	p := &gpgKeyProvider{}
+	var err error
+	if publicKeyPath != "" {
+		p.publicKeys, err = gpgx.ReadArmoredKeyFile(publicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if privateKeyPath != "" {
+		p.privateKeys, err = gpgx.ReadPrivateKeyFile(privateKeyPath, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// ProviderID labels the key stanzas of GPG providers, so readers skip stanzas for
+// other kinds of provider and try each GPG stanza with their private key.
+func (p *gpgKeyProvider) ProviderID() string {
+	return "gpg"
+}
+
+func (p *gpgKeyProvider) GenerateKey() (*stream.DataKey, error) {
+	key := make([]byte, gpgDataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+	wrapped, err := p.WrapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &stream.DataKey{
+		Plaintext:  key,
+		WrappedKey: wrapped,
+	}, nil
+}
+
+func (p *gpgKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if len(p.privateKeys) == 0 {
+		return nil, errors.New("gpg: missing private keys")
+	}
+	if len(wrappedKey) == 0 {
+		return nil, errors.New("gpg: missing wrapped key")
+	}
+
+	// gpgx.Decrypt takes a single private key, so each key in the keyring is tried
+	var errs []error
+	for _, entity := range p.privateKeys {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		key, err := gpgx.Decrypt(wrappedKey, openpgp.EntityList{entity})
+		if err == nil {
+			return key, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("gpg: missing private keys")
+	}
+	return nil, fmt.Errorf("gpg: unwrapping data key: %w", errors.Join(errs...))
+}
+
+// WrapKey encrypts an existing data key to the public keys, so a stream written with
+// another KeyProvider can name GPG keys as recipients.
+func (p *gpgKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	if len(p.publicKeys) == 0 {
+		return nil, errors.New("gpg: missing public keys")
+	}
+
+	wrapped, err := gpgx.Encrypt(plaintext, p.publicKeys)
+	if err != nil {
+		return nil, fmt.Errorf("gpg: wrapping data key: %w", err)
+	}
+	return wrapped, nil
+}
+
+var _ stream.KeyWrapper = (&gpgKeyProvider{})
+var _ stream.ProviderIdentifier = (&gpgKeyProvider{})
@@ -0,0 +1,158 @@
+package cryptfs
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/cryptfs/stream"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGPGKeyProvider(t *testing.T) {
+	dir := filepath.Join("internal", "gpgx", "testdata")
+	kp, err := NewGPGKeyProviderFile(filepath.Join(dir, "key.pub"), filepath.Join(dir, "key.priv"), []byte("password"))
+	require.NoError(t, err)
+
+	original := bytes.Repeat([]byte("hello, world "), 10_000)
+	read := func(kp stream.KeyProvider, data []byte) ([]byte, error) {
+		r, err := stream.NewReader(bytes.NewReader(data), kp)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		data := writeStream(t, kp, original, stream.WithChunkSize(4096))
+
+		info, err := stream.Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(info.WrappedKey), "-----BEGIN PGP MESSAGE-----"))
+
+		got, err := read(kp, data)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+
+		// Each stream has its own data key
+		other := writeStream(t, kp, original)
+		otherInfo, err := stream.Inspect(bytes.NewReader(other))
+		require.NoError(t, err)
+		require.NotEqual(t, info.WrappedKey, otherInfo.WrappedKey)
+	})
+
+	t.Run("partners", func(t *testing.T) {
+		partnerPub, partnerPriv := newGPGKey(t)
+
+		partnerWriter, err := NewGPGKeyProvider(bytes.NewReader(partnerPub), nil, nil)
+		require.NoError(t, err)
+		partnerReader, err := NewGPGKeyProvider(nil, bytes.NewReader(partnerPriv), nil)
+		require.NoError(t, err)
+
+		data := writeStream(t, kp, original, stream.WithRecipients(partnerWriter))
+		for _, p := range []stream.KeyProvider{kp, partnerReader} {
+			got, err := read(p, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+
+		// Only the data key is encrypted to the partner
+		_, err = read(partnerReader, writeStream(t, kp, original))
+		require.ErrorContains(t, err, "gpg: unwrapping data key")
+
+		// Both public keys in one keyring
+		both, err := NewGPGKeyProviderFile(filepath.Join(dir, "key.pub"), "", nil)
+		require.NoError(t, err)
+		gp := both.(*gpgKeyProvider)
+		partnerKeys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(partnerPub))
+		require.NoError(t, err)
+		gp.publicKeys = append(gp.publicKeys, partnerKeys...)
+
+		data = writeStream(t, both, original)
+		for _, p := range []stream.KeyProvider{kp, partnerReader} {
+			got, err := read(p, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+	})
+
+	t.Run("private keyring", func(t *testing.T) {
+		partnerPub, partnerPriv := newGPGKey(t)
+		partnerWriter, err := NewGPGKeyProvider(bytes.NewReader(partnerPub), nil, nil)
+		require.NoError(t, err)
+
+		// Both private keys in one keyring
+		both, err := NewGPGKeyProviderFile("", filepath.Join(dir, "key.priv"), []byte("password"))
+		require.NoError(t, err)
+		gp := both.(*gpgKeyProvider)
+		partnerKeys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(partnerPriv))
+		require.NoError(t, err)
+		gp.privateKeys = append(gp.privateKeys, partnerKeys...)
+
+		for _, w := range []stream.KeyProvider{kp, partnerWriter} {
+			got, err := read(both, writeStream(t, w, original))
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+
+		other, _ := newGPGKey(t)
+		otherWriter, err := NewGPGKeyProvider(bytes.NewReader(other), nil, nil)
+		require.NoError(t, err)
+		_, err = read(both, writeStream(t, otherWriter, original))
+		require.ErrorContains(t, err, "gpg: unwrapping data key")
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		writeOnly, err := NewGPGKeyProviderFile(filepath.Join(dir, "key.pub"), "", nil)
+		require.NoError(t, err)
+		data := writeStream(t, writeOnly, original)
+
+		_, err = read(writeOnly, data)
+		require.ErrorContains(t, err, "gpg: missing private keys")
+
+		readOnly, err := NewGPGKeyProviderFile("", filepath.Join(dir, "key.priv"), []byte("password"))
+		require.NoError(t, err)
+		got, err := read(readOnly, data)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+
+		_, err = stream.NewWriter(io.Discard, readOnly)
+		require.ErrorContains(t, err, "gpg: missing public keys")
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		_, err := NewGPGKeyProviderFile(filepath.Join(dir, "missing.pub"), "", nil)
+		require.Error(t, err)
+
+		_, err = NewGPGKeyProviderFile("", filepath.Join(dir, "key.priv"), []byte("wrong"))
+		require.ErrorContains(t, err, "decrypting private key failed")
+	})
+}
+
+// newGPGKey returns a new armored public and private key.
+func newGPGKey(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	conf := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+	entity, err := openpgp.NewEntity("Partner", "", "partner@example.com", conf)
+	require.NoError(t, err)
+
+	var pub, priv bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+
+	w, err = armor.Encode(&priv, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(w, conf))
+	require.NoError(t, w.Close())
+
+	return pub.Bytes(), priv.Bytes()
+}
@@ -1,6 +1,7 @@
 package cryptfs
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 
@@ -21,11 +22,16 @@ func NewVaultKeyProvider(conf VaultConfig) (stream.KeyProvider, error) {
 }
 
 func (p *vaultKeyProvider) GenerateKey() (*stream.DataKey, error) {
+	return p.GenerateKeyContext(context.Background())
+}
+
+func (p *vaultKeyProvider) GenerateKeyContext(ctx context.Context) (*stream.DataKey, error) {
 	if err := p.auth(); err != nil {
 		return nil, err
 	}
 
-	res, err := p.client.Logical().Write(
+	res, err := p.client.Logical().WriteWithContext(
+		ctx,
 		fmt.Sprintf("/transit/datakey/plaintext/%s", p.config.KeyName),
 		nil,
 	)
@@ -56,6 +62,10 @@ func (p *vaultKeyProvider) GenerateKey() (*stream.DataKey, error) {
 }
 
 func (p *vaultKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	return p.UnwrapKeyContext(context.Background(), wrappedKey)
+}
+
+func (p *vaultKeyProvider) UnwrapKeyContext(ctx context.Context, wrappedKey []byte) ([]byte, error) {
 	if err := p.auth(); err != nil {
 		return nil, err
 	}
@@ -63,7 +73,8 @@ func (p *vaultKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
 	params := map[string]interface{}{
 		"ciphertext": string(wrappedKey),
 	}
-	res, err := p.client.Logical().Write(
+	res, err := p.client.Logical().WriteWithContext(
+		ctx,
 		fmt.Sprintf("/transit/decrypt/%s", p.config.KeyName),
 		params,
 	)
@@ -83,3 +94,37 @@ func (p *vaultKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+// WrapKey encrypts an existing data key with the transit key, so a stream written
+// with another KeyProvider can name this Vault as a recipient.
+func (p *vaultKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	return p.WrapKeyContext(context.Background(), plaintext)
+}
+
+func (p *vaultKeyProvider) WrapKeyContext(ctx context.Context, plaintext []byte) ([]byte, error) {
+	if err := p.auth(); err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	}
+	res, err := p.client.Logical().WriteWithContext(
+		ctx,
+		fmt.Sprintf("/transit/encrypt/%s", p.config.KeyName),
+		params,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	ciphertext, ok := res.Data["ciphertext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("casting ciphertext key to string from %T", res.Data["ciphertext"])
+	}
+	return []byte(ciphertext), nil
+}
+
+var _ stream.ContextKeyProvider = (&vaultKeyProvider{})
+var _ stream.KeyWrapper = (&vaultKeyProvider{})
+var _ stream.ContextKeyWrapper = (&vaultKeyProvider{})
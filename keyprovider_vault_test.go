@@ -1,8 +1,13 @@
 package cryptfs
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"testing"
 
+	"github.com/moov-io/cryptfs/stream"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -38,4 +43,53 @@ func TestVaultKeyProvider(t *testing.T) {
 
 		require.NotEqual(t, dk1.Plaintext, dk2.Plaintext, "each data key should be unique")
 	})
+
+	t.Run("wrap key", func(t *testing.T) {
+		kw, ok := kp.(stream.KeyWrapper)
+		require.True(t, ok)
+
+		key := bytes.Repeat([]byte{0x42}, 32)
+		wrapped, err := kw.WrapKey(key)
+		require.NoError(t, err)
+
+		recovered, err := kp.UnwrapKey(wrapped)
+		require.NoError(t, err)
+		require.Equal(t, key, recovered)
+	})
+
+	t.Run("recipient", func(t *testing.T) {
+		other, err := NewVaultKeyProvider(conf)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		w, err := stream.NewWriter(&buf, kp, stream.WithRecipients(other))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("hello, recipients"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		info, err := stream.Inspect(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Len(t, info.Stanzas, 2)
+
+		r, err := stream.NewReader(bytes.NewReader(buf.Bytes()), other)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "hello, recipients", string(got))
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ckp, ok := kp.(stream.ContextKeyProvider)
+		require.True(t, ok)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := ckp.GenerateKeyContext(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+
+		_, err = stream.NewWriterContext(ctx, io.Discard, kp)
+		require.ErrorIs(t, err, context.Canceled)
+	})
 }
@@ -0,0 +1,219 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrReadOnly is returned when writing to a FS created with Wrap.
+var ErrReadOnly = errors.New("cryptfs: read-only filesystem")
+
+// NewWithRoot returns a FS instance which resolves every name relative to dir.
+//
+// Names must satisfy fs.ValidPath, so names containing ".." elements or which are
+// rooted are rejected with fs.ErrInvalid. Files are read and written through an
+// os.Root, so symbolic links which point outside of dir are rejected as well.
+func NewWithRoot(dir string, cryptor Cryptor) (*FS, error) {
+	if dir == "" {
+		return nil, errors.New("empty root directory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening root: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("root %s is not a directory", dir)
+	}
+
+	fsys, err := New(cryptor)
+	if err != nil {
+		return nil, err
+	}
+	fsys.root, err = os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening root: %w", err)
+	}
+	fsys.base = fsys.root.FS()
+	return fsys, nil
+}
+
+// Wrap returns a read-only FS instance which decrypts files read from inner.
+// This allows the decrypting layer to sit on top of embed.FS, fstest.MapFS, etc.
+//
+// Write operations on the returned FS fail with ErrReadOnly.
+func Wrap(inner fs.FS, cryptor Cryptor) (*FS, error) {
+	if inner == nil {
+		return nil, errors.New("nil fs.FS")
+	}
+	fsys, err := New(cryptor)
+	if err != nil {
+		return nil, err
+	}
+	fsys.base = inner
+	return fsys, nil
+}
+
+// localFS is the part of the local filesystem which an FS writes to, either every
+// path through the os package or the names within an *os.Root.
+type localFS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// osFS writes to local paths with the os package.
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// rootFS writes to the names within an os.Root.
+type rootFS struct {
+	*os.Root
+}
+
+// MkdirAll creates each missing directory of name in turn, as os.Root only has
+// Mkdir before Go 1.25.
+func (r rootFS) MkdirAll(name string, perm fs.FileMode) error {
+	var dir string
+	for _, elem := range strings.Split(filepath.ToSlash(filepath.Clean(name)), "/") {
+		dir = filepath.Join(dir, elem)
+		err := r.Mkdir(dir, perm)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		info, statErr := r.Stat(dir)
+		if statErr != nil {
+			return statErr
+		}
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// Rename renames oldname to newname once both of their parent directories are
+// found within the root, as os.Root only has Rename from Go 1.25. Rename never
+// follows a symbolic link in the last element of either name.
+func (r rootFS) Rename(oldname, newname string) error {
+	for _, name := range []string{oldname, newname} {
+		dir, err := r.Open(filepath.Dir(name))
+		if err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+		dir.Close()
+	}
+	return os.Rename(filepath.Join(r.Name(), oldname), filepath.Join(r.Name(), newname))
+}
+
+var _ localFS = osFS{}
+var _ localFS = rootFS{}
+
+// localPath returns the filesystem which name is written to and name within it.
+func (fsys *FS) localPath(op, name string) (localFS, string, error) {
+	if fsys.base == nil {
+		// Names are local paths when FS isn't rooted
+		return osFS{}, name, nil
+	}
+	if fsys.root == nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+	}
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return rootFS{fsys.root}, filepath.FromSlash(name), nil
+}
+
+func (fsys *FS) openRaw(name string) (fs.File, error) {
+	if fsys.base == nil {
+		return os.Open(name)
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return fsys.base.Open(name)
+}
+
+func (fsys *FS) readRaw(name string) ([]byte, error) {
+	if fsys.base == nil {
+		return os.ReadFile(name)
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return fs.ReadFile(fsys.base, name)
+}
+
+// join combines a directory and a name within it.
+func (fsys *FS) join(dir, name string) string {
+	if fsys.base == nil {
+		return filepath.Join(dir, name)
+	}
+	return path.Join(dir, name)
+}
+
+func (fsys *FS) statRaw(name string) (fs.FileInfo, error) {
+	if fsys.base == nil {
+		return os.Stat(name)
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	return fs.Stat(fsys.base, name)
+}
+
+func (fsys *FS) readDirRaw(name string) ([]fs.DirEntry, error) {
+	if fsys.base == nil {
+		return os.ReadDir(name)
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return fs.ReadDir(fsys.base, name)
+}
@@ -0,0 +1,167 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithRoot(t *testing.T) {
+	cc, err := NewAESCryptor([]byte(strings.Repeat("1", 16)))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	fsys, err := NewWithRoot(dir, cc)
+	require.NoError(t, err)
+	fsys.SetCoder(Base64())
+
+	err = fsys.WriteFile("hello.txt", []byte("hello, world"), 0600)
+	require.NoError(t, err)
+
+	// The file is written under dir
+	bs, err := os.ReadFile(filepath.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	require.NotEqual(t, "hello, world", string(bs))
+
+	bs, err = fsys.ReadFile("hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(bs))
+
+	file, err := fsys.Open("hello.txt")
+	require.NoError(t, err)
+	bs, err = io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(bs))
+	require.NoError(t, file.Close())
+
+	t.Run("path traversal", func(t *testing.T) {
+		for _, name := range []string{"../hello.txt", "/etc/passwd", "a/../../b", ""} {
+			_, err := fsys.ReadFile(name)
+			require.ErrorIs(t, err, fs.ErrInvalid, name)
+
+			_, err = fsys.Open(name)
+			require.ErrorIs(t, err, fs.ErrInvalid, name)
+
+			err = fsys.WriteFile(name, []byte("data"), 0600)
+			require.ErrorIs(t, err, fs.ErrInvalid, name)
+		}
+	})
+
+	t.Run("symlinks", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("creating symlinks requires privileges on windows")
+		}
+
+		outside := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0600))
+		require.NoError(t, os.Symlink(outside, filepath.Join(dir, "outside")))
+		require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "secret.txt")))
+
+		// Links out of the root are neither read nor written through
+		for _, name := range []string{"outside/secret.txt", "secret.txt"} {
+			_, err := fsys.ReadFile(name)
+			require.Error(t, err, name)
+
+			_, err = fsys.Open(name)
+			require.Error(t, err, name)
+
+			_, err = fsys.Stat(name)
+			require.Error(t, err, name)
+		}
+		err := fsys.WriteFile("outside/new.txt", []byte("data"), 0600)
+		require.Error(t, err)
+
+		_, err = fsys.Create("outside/created.txt")
+		require.Error(t, err)
+		require.Error(t, fsys.MkdirAll("outside/dir", 0700))
+		require.Error(t, fsys.Rename("hello.txt", "outside/hello.txt"))
+
+		_, err = fsys.Sub("outside")
+		require.Error(t, err)
+
+		entries, err := os.ReadDir(outside)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		// Links which stay within the root still work
+		require.NoError(t, os.Symlink("hello.txt", filepath.Join(dir, "link.txt")))
+		bs, err := fsys.ReadFile("link.txt")
+		require.NoError(t, err)
+		require.Equal(t, "hello, world", string(bs))
+	})
+
+	t.Run("invalid root", func(t *testing.T) {
+		_, err := NewWithRoot("", cc)
+		require.Error(t, err)
+
+		_, err = NewWithRoot(filepath.Join(dir, "missing"), cc)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = NewWithRoot(filepath.Join(dir, "hello.txt"), cc)
+		require.ErrorContains(t, err, "is not a directory")
+
+		_, err = NewWithRoot(dir, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestWrap(t *testing.T) {
+	cc, err := NewAESCryptor([]byte(strings.Repeat("1", 16)))
+	require.NoError(t, err)
+
+	writer, err := New(cc)
+	require.NoError(t, err)
+	writer.SetCompression(Gzip())
+
+	encrypted, err := writer.Disfigure([]byte("hello, world"))
+	require.NoError(t, err)
+
+	inner := fstest.MapFS{
+		"dir/hello.txt": &fstest.MapFile{Data: encrypted, Mode: 0600},
+	}
+
+	fsys, err := Wrap(inner, cc)
+	require.NoError(t, err)
+	fsys.SetCompression(Gzip())
+
+	bs, err := fsys.ReadFile("dir/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(bs))
+
+	bs, err = fs.ReadFile(fsys, "dir/hello.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(bs))
+
+	_, err = fsys.ReadFile("../dir/hello.txt")
+	require.ErrorIs(t, err, fs.ErrInvalid)
+
+	err = fsys.WriteFile("dir/other.txt", []byte("data"), 0600)
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	_, err = Wrap(nil, cc)
+	require.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func BenchmarkStream(b *testing.B) {
+	kp := NewStaticKeyProvider([]byte("1234567890123456"))
+
+	input := make([]byte, 16*1024*1024)
+	_, err := rand.Read(input)
+	require.NoError(b, err)
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("write_concurrency_%d", concurrency), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+
+			var buf bytes.Buffer
+			for b.Loop() {
+				buf.Reset()
+
+				w, err := NewWriter(&buf, kp, WithConcurrency(concurrency))
+				require.NoError(b, err)
+				_, err = w.Write(input)
+				require.NoError(b, err)
+				require.NoError(b, w.Close())
+			}
+		})
+
+		b.Run(fmt.Sprintf("read_concurrency_%d", concurrency), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, kp)
+			require.NoError(b, err)
+			_, err = w.Write(input)
+			require.NoError(b, err)
+			require.NoError(b, w.Close())
+
+			for b.Loop() {
+				r, err := NewReader(bytes.NewReader(buf.Bytes()), kp, WithConcurrency(concurrency))
+				require.NoError(b, err)
+
+				n, err := io.Copy(io.Discard, r)
+				require.NoError(b, err)
+				require.Equal(b, int64(len(input)), n)
+			}
+		})
+	}
+}
+
+func BenchmarkCipher(b *testing.B) {
+	kp := NewStaticKeyProvider([]byte("12345678901234567890123456789012"))
+
+	input := make([]byte, 16*1024*1024)
+	_, err := rand.Read(input)
+	require.NoError(b, err)
+
+	for _, c := range []Cipher{AESGCM, ChaCha20Poly1305, XChaCha20Poly1305} {
+		b.Run(c.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+
+			var buf bytes.Buffer
+			for b.Loop() {
+				buf.Reset()
+
+				w, err := NewWriter(&buf, kp, WithCipher(c))
+				require.NoError(b, err)
+				_, err = w.Write(input)
+				require.NoError(b, err)
+				require.NoError(b, w.Close())
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher is the AEAD cipher suite which encrypts the chunks of a stream. Every suite
+// uses the same chunk layout, only the header records which one was used.
+type Cipher byte
+
+const (
+	// AESGCM is AES-GCM with a 128, 192 or 256-bit data key. It's the default, and
+	// the only suite of streams written before the suite was recorded.
+	AESGCM Cipher = 0x00
+
+	// ChaCha20Poly1305 is ChaCha20-Poly1305 with a 256-bit data key. It's faster than
+	// AES-GCM on CPUs without AES instructions.
+	ChaCha20Poly1305 Cipher = 0x01
+
+	// XChaCha20Poly1305 is XChaCha20-Poly1305 with a 256-bit data key. Its 192-bit
+	// nonces have 19 random bytes per stream instead of 7, so they stay unique without
+	// relying on a fresh data key for each stream.
+	XChaCha20Poly1305 Cipher = 0x02
+)
+
+// xNoncePrefixSize is the number of random nonce bytes XChaCha20Poly1305 adds
+// to the nonce prefix.
+const xNoncePrefixSize = chacha20poly1305.NonceSizeX - nonceSize
+
+func (c Cipher) String() string {
+	switch c {
+	case AESGCM:
+		return "AES-GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	}
+	return fmt.Sprintf("Cipher(0x%02x)", byte(c))
+}
+
+func supportedCipher(c Cipher) bool {
+	return c == AESGCM || c == ChaCha20Poly1305 || c == XChaCha20Poly1305
+}
+
+// newAEAD returns the cipher suite of h keyed with the data key.
+func newAEAD(key []byte, h *fileHeader) (cipher.AEAD, error) {
+	switch h.Cipher {
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("creating AES cipher: %w", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("creating GCM: %w", err)
+		}
+		return gcm, nil
+
+	case ChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("creating %v: %w", h.Cipher, err)
+		}
+		return aead, nil
+
+	case XChaCha20Poly1305:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("creating %v: %w", h.Cipher, err)
+		}
+		return &xNonceAEAD{aead: aead, prefix: h.XNoncePrefix}, nil
+	}
+	return nil, fmt.Errorf("unsupported cipher %v", h.Cipher)
+}
+
+// xNonceAEAD prepends the extra nonce prefix from the header to the 12-byte nonce
+// of each chunk, so XChaCha20Poly1305 chunks are laid out like those of other suites.
+type xNonceAEAD struct {
+	aead   cipher.AEAD
+	prefix [xNoncePrefixSize]byte
+}
+
+func (a *xNonceAEAD) NonceSize() int {
+	return nonceSize
+}
+
+func (a *xNonceAEAD) Overhead() int {
+	return a.aead.Overhead()
+}
+
+func (a *xNonceAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return a.aead.Seal(dst, a.nonce(nonce), plaintext, additionalData)
+}
+
+func (a *xNonceAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return a.aead.Open(dst, a.nonce(nonce), ciphertext, additionalData)
+}
+
+func (a *xNonceAEAD) nonce(nonce []byte) []byte {
+	if len(nonce) != nonceSize {
+		panic("stream: incorrect nonce length given to XChaCha20-Poly1305")
+	}
+	return append(a.prefix[:len(a.prefix):len(a.prefix)], nonce...)
+}
@@ -0,0 +1,127 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCipher(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	kp := NewStaticKeyProvider(key)
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100)
+
+	write := func(t *testing.T, c Cipher, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, append(opts, WithCipher(c))...)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	for _, c := range []Cipher{AESGCM, ChaCha20Poly1305, XChaCha20Poly1305} {
+		t.Run(c.String(), func(t *testing.T) {
+			for _, concurrency := range []int{0, 4} {
+				for _, compress := range []bool{false, true} {
+					t.Run(fmt.Sprintf("concurrency=%d/compress=%v", concurrency, compress), func(t *testing.T) {
+						opts := []Option{WithChunkSize(100), WithConcurrency(concurrency)}
+						if compress {
+							opts = append(opts, WithCompression())
+						}
+						data := write(t, c, opts...)
+
+						info, err := Inspect(bytes.NewReader(data))
+						require.NoError(t, err)
+						require.Equal(t, c, info.Cipher)
+
+						r, err := NewReader(bytes.NewReader(data), kp, WithConcurrency(concurrency))
+						require.NoError(t, err)
+						got, err := io.ReadAll(r)
+						require.NoError(t, err)
+						require.Equal(t, original, got)
+					})
+				}
+			}
+
+			t.Run("ReaderAt", func(t *testing.T) {
+				data := write(t, c, WithChunkSize(100))
+
+				r, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), kp)
+				require.NoError(t, err)
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.Equal(t, original, got)
+			})
+
+			t.Run("Rewrap", func(t *testing.T) {
+				oldKP := newWrappingKeyProvider(t, "old")
+				newKP := newWrappingKeyProvider(t, "new")
+
+				var buf bytes.Buffer
+				w, err := NewWriter(&buf, oldKP, WithCipher(c))
+				require.NoError(t, err)
+				_, err = w.Write(original)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+
+				var rewrapped bytes.Buffer
+				require.NoError(t, Rewrap(&buf, &rewrapped, oldKP, newKP))
+
+				r, err := NewReader(&rewrapped, newKP)
+				require.NoError(t, err)
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.Equal(t, original, got)
+			})
+		})
+	}
+
+	t.Run("cipher is authenticated", func(t *testing.T) {
+		data := write(t, ChaCha20Poly1305)
+
+		// Switching the suite in the header fails to decrypt
+		i := bytes.Index(data, []byte{fieldCipher, 0, 1, byte(ChaCha20Poly1305)})
+		require.Positive(t, i)
+		data[i+3] = byte(AESGCM)
+
+		r, err := NewReader(bytes.NewReader(data), kp)
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+
+	t.Run("XChaCha20Poly1305 nonce prefix", func(t *testing.T) {
+		info, err := Inspect(bytes.NewReader(write(t, XChaCha20Poly1305)))
+		require.NoError(t, err)
+		require.Equal(t, XChaCha20Poly1305, info.Cipher)
+
+		h1, _, err := readHeader(bytes.NewReader(write(t, XChaCha20Poly1305)))
+		require.NoError(t, err)
+		h2, _, err := readHeader(bytes.NewReader(write(t, XChaCha20Poly1305)))
+		require.NoError(t, err)
+		require.NotEqual(t, h1.XNoncePrefix, h2.XNoncePrefix)
+		require.NotEqual(t, [xNoncePrefixSize]byte{}, h1.XNoncePrefix)
+	})
+
+	t.Run("key size", func(t *testing.T) {
+		kp := NewStaticKeyProvider([]byte("1234567890123456"))
+
+		_, err := NewWriter(io.Discard, kp, WithCipher(ChaCha20Poly1305))
+		require.ErrorContains(t, err, "creating ChaCha20-Poly1305")
+
+		_, err = NewWriter(io.Discard, kp, WithCipher(XChaCha20Poly1305))
+		require.ErrorContains(t, err, "creating XChaCha20-Poly1305")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, kp, WithCipher(Cipher(0x7F)))
+		require.ErrorContains(t, err, "unsupported cipher Cipher(0x7f)")
+	})
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses data before it's encrypted.
+type Codec byte
+
+const (
+	// CodecNone leaves data uncompressed, it's the default.
+	CodecNone Codec = 0x00
+
+	// CodecGzip is gzip with levels 1 (fastest) to 9 (smallest).
+	CodecGzip Codec = 0x01
+
+	// CodecFlate is raw DEFLATE without gzip's header and checksum, with levels 1 to 9.
+	// The chunks are already authenticated, so the checksum isn't needed.
+	CodecFlate Codec = 0x02
+
+	// CodecZstd is Zstandard with levels 1 to 22 as in the zstd command. The levels
+	// are mapped to the nearest speed of github.com/klauspost/compress/zstd.
+	CodecZstd Codec = 0x03
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecGzip:
+		return "gzip"
+	case CodecFlate:
+		return "flate"
+	case CodecZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Codec(0x%02x)", byte(c))
+}
+
+func supportedCodec(c Codec) bool {
+	return c == CodecNone || c == CodecGzip || c == CodecFlate || c == CodecZstd
+}
+
+// checkLevel returns an error when level isn't valid for c. Zero is the codec's
+// default level.
+func checkLevel(c Codec, level int) error {
+	maxLevel := 0
+	switch c {
+	case CodecGzip, CodecFlate:
+		maxLevel = flate.BestCompression
+	case CodecZstd:
+		maxLevel = 22
+	}
+	if level < 0 || level > maxLevel {
+		return fmt.Errorf("invalid %v compression level %d", c, level)
+	}
+	return nil
+}
+
+// newCompressor returns a writer which compresses into w with c at level.
+func newCompressor(c Codec, level int, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CodecGzip, CodecFlate:
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		if c == CodecGzip {
+			return gzip.NewWriterLevel(w, level)
+		}
+		return flate.NewWriter(w, level)
+
+	case CodecZstd:
+		opts := []zstd.EOption{
+			// Chunks are encrypted concurrently with WithConcurrency, so keep
+			// compression on the caller's goroutine.
+			zstd.WithEncoderConcurrency(1),
+		}
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	return nil, fmt.Errorf("unsupported compression codec %v", c)
+}
+
+// newDecompressor returns a reader which decompresses r with c.
+func newDecompressor(c Codec, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewReader(r)
+
+	case CodecFlate:
+		return flate.NewReader(r), nil
+
+	case CodecZstd:
+		// Decode on the caller's goroutine like the other codecs
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression codec %v", c)
+}
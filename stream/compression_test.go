@@ -0,0 +1,130 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressionCodec(t *testing.T) {
+	kp := NewStaticKeyProvider([]byte("1234567890123456"))
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+
+	write := func(t *testing.T, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	read := func(data []byte, opts ...Option) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(data), kp, opts...)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+
+	levels := map[Codec][]int{
+		CodecNone:  {0},
+		CodecGzip:  {0, 1, 9},
+		CodecFlate: {0, 1, 9},
+		CodecZstd:  {0, 1, 3, 22},
+	}
+	for _, codec := range []Codec{CodecNone, CodecGzip, CodecFlate, CodecZstd} {
+		t.Run(codec.String(), func(t *testing.T) {
+			for _, level := range levels[codec] {
+				for _, concurrency := range []int{0, 4} {
+					t.Run(fmt.Sprintf("level=%d/concurrency=%d", level, concurrency), func(t *testing.T) {
+						data := write(t, WithCompressionCodec(codec, level), WithChunkSize(1000), WithConcurrency(concurrency))
+						if codec != CodecNone {
+							require.Less(t, len(data), len(original)/4)
+						}
+
+						info, err := Inspect(bytes.NewReader(data))
+						require.NoError(t, err)
+						require.Equal(t, codec, info.Codec)
+						require.Equal(t, codec != CodecNone, info.Compressed)
+						require.Equal(t, int64(len(original)), info.PlaintextSize)
+
+						got, err := read(data, WithConcurrency(concurrency))
+						require.NoError(t, err)
+						require.Equal(t, original, got)
+					})
+				}
+			}
+		})
+	}
+
+	t.Run("gzip uses the codec field", func(t *testing.T) {
+		for _, opt := range []Option{WithCompression(), WithCompressionCodec(CodecGzip, 9)} {
+			h, _, err := readHeader(bytes.NewReader(write(t, opt)))
+			require.NoError(t, err)
+			require.Zero(t, h.Flags)
+			require.Equal(t, CodecGzip, h.Codec)
+		}
+	})
+
+	t.Run("codec is authenticated", func(t *testing.T) {
+		data := write(t, WithCompressionCodec(CodecZstd, 0))
+
+		i := bytes.Index(data, []byte{fieldCodec, 0, 1, byte(CodecZstd)})
+		require.Positive(t, i)
+		data[i+3] = byte(CodecFlate)
+
+		_, err := read(data)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+
+	t.Run("ReaderAt", func(t *testing.T) {
+		data := write(t, WithCompressionCodec(CodecZstd, 0))
+
+		_, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), kp)
+		require.ErrorContains(t, err, "random access requires an uncompressed stream")
+	})
+
+	t.Run("Rewrap", func(t *testing.T) {
+		oldKP := newWrappingKeyProvider(t, "old")
+		newKP := newWrappingKeyProvider(t, "new")
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, oldKP, WithCompressionCodec(CodecFlate, 0))
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		var rewrapped bytes.Buffer
+		require.NoError(t, Rewrap(&buf, &rewrapped, oldKP, newKP))
+
+		r, err := NewReader(&rewrapped, newKP)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, kp, WithCompressionCodec(CodecGzip, 10))
+		require.ErrorContains(t, err, "invalid gzip compression level 10")
+
+		_, err = NewWriter(io.Discard, kp, WithCompressionCodec(CodecZstd, 23))
+		require.ErrorContains(t, err, "invalid zstd compression level 23")
+
+		_, err = NewWriter(io.Discard, kp, WithCompressionCodec(CodecFlate, -1))
+		require.ErrorContains(t, err, "invalid flate compression level -1")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, kp, WithCompressionCodec(Codec(0x7F), 0))
+		require.ErrorContains(t, err, "unsupported compression codec Codec(0x7f)")
+	})
+}
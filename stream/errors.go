@@ -4,3 +4,14 @@ import "errors"
 
 // ErrClosed is returned when Write, Read, or Close is called on an already-closed Writer or Reader.
 var ErrClosed = errors.New("stream: use of closed Writer or Reader")
+
+// ErrTruncated is returned when a stream ends before its final chunk.
+var ErrTruncated = errors.New("stream: truncated before final chunk")
+
+// ErrDigestMismatch is returned when the plaintext read from a stream doesn't match
+// the SHA-256 digest stored by the Writer.
+var ErrDigestMismatch = errors.New("stream: plaintext digest mismatch")
+
+// ErrLegacyVersion is returned when reading a format version 1 stream without
+// WithLegacyV1.
+var ErrLegacyVersion = errors.New("stream: format version 1 requires WithLegacyV1")
@@ -2,17 +2,42 @@ package stream
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"slices"
 )
 
 // So we know that it's new format of the encrypted file
 var magic = [4]byte{'C', 'R', 'F', 'S'}
 
 const (
-	formatVersion = 0x01
+	formatVersion1 = 0x01
+
+	// formatVersion3 replaces the wrapped key with a list of typed header fields,
+	// records the chunk size, marks the final chunk in its nonce so truncation at a
+	// chunk boundary is detected, and ends with a trailer holding the stream's sizes.
+	// Version 2 was never released.
+	formatVersion3 = 0x03
+
+	// formatVersion is the version written by NewWriter.
+	formatVersion = formatVersion3
+
+	// finalChunkFlag is set in the counter of the final chunk's nonce (version 3).
+	// Since the nonce is authenticated by GCM the flag can't be moved to an earlier chunk.
+	finalChunkFlag = 0x80
+
+	// rewrapFlag is set in the counter of chunk 0's nonce by Rewrap, whose remaining
+	// counter bits hold a random epoch. Chunk 0 is sealed again under the new header,
+	// and the epoch keeps it from reusing the nonce of any earlier seal.
+	rewrapFlag = 0x40
+
+	// rewrapEpochMask covers the counter bits below rewrapFlag
+	rewrapEpochMask = 1<<38 - 1
 
 	flagGzip = 0x01
 
@@ -22,15 +47,95 @@ const (
 	// DefaultChunkSize is the plaintext size per chunk before encryption.
 	DefaultChunkSize = 64 * 1024
 
-	// fixedHeaderSize is magic(4) + version(1) + flags(1) + noncePrefix(7) + wrappedKeyLen(2) = 15
+	// maxChunkSize keeps chunks well within their 4-byte length
+	maxChunkSize = 1 << 30
+
+	// headerPrefixSize is magic(4) + version(1) + flags(1) + noncePrefix(7) = 13
+	headerPrefixSize = 13
+
+	// fixedHeaderSize is the header prefix + wrappedKeyLen(2) = 15 of version 1
 	fixedHeaderSize = 15
+
+	// fieldsHeaderSize is the header prefix + fieldsLen(4) = 17 of version 3
+	fieldsHeaderSize = 17
+
+	// maxHeaderFieldsSize limits the memory allocated for header fields while reading
+	maxHeaderFieldsSize = 1 << 20
+
+	// trailerSize is plaintextSize(8) + ciphertextSize(8)
+	trailerSize = 16
+
+	// digestSize is the SHA-256 of the plaintext, held by the final chunk of version 3.
+	digestSize = sha256.Size
+)
+
+// Header field types of version 3. Each field is type(1) + length(2) + value.
+const (
+	fieldChunkSize  = 0x01 // uint32, required
+	fieldWrappedKey = 0x02
+	fieldStanza     = 0x03 // idLen(1) + id + wrapped key, repeated for each recipient
+	fieldMetadata   = 0x04 // keyLen(1) + key + value, repeated for each entry in key order
+	fieldCipher     = 0x05 // Cipher(1) + nonce prefix extension of XChaCha20Poly1305, AESGCM when absent
+	fieldCodec      = 0x06 // Codec(1) of compression
 )
 
+// Stanza is the data key wrapped for one of the recipients of a stream.
+type Stanza struct {
+	ProviderID string // from ProviderIdentifier, may be empty
+	WrappedKey []byte
+}
+
 type fileHeader struct {
 	Version     byte
 	Flags       byte
 	NoncePrefix [noncePrefixSize]byte
 	WrappedKey  []byte
+
+	// ChunkSize is the plaintext size of each chunk, it's only stored from version 3.
+	ChunkSize uint32
+
+	// Stanzas replace WrappedKey when a stream has several recipients (version 3).
+	Stanzas []Stanza
+
+	// Metadata is set with WithMetadata (version 3).
+	Metadata map[string]string
+
+	// Cipher encrypts the chunks (version 3). XNoncePrefix extends NoncePrefix
+	// for XChaCha20Poly1305.
+	Cipher       Cipher
+	XNoncePrefix [xNoncePrefixSize]byte
+
+	// Codec compresses data (version 3). Version 1 sets flagGzip instead.
+	Codec Codec
+}
+
+// codec returns the compression codec of the stream.
+func (h *fileHeader) codec() Codec {
+	if h.Version == formatVersion1 && h.Flags&flagGzip != 0 {
+		return CodecGzip
+	}
+	return h.Codec
+}
+
+// trailer follows the end marker of version 3 streams. It's authenticated as
+// additional data of the final chunk.
+type trailer struct {
+	PlaintextSize  uint64 // bytes written to the Writer, before compression
+	CiphertextSize uint64 // bytes of all chunks including their length, between header and end marker
+}
+
+func (t trailer) bytes() []byte {
+	bs := make([]byte, trailerSize)
+	binary.BigEndian.PutUint64(bs[0:8], t.PlaintextSize)
+	binary.BigEndian.PutUint64(bs[8:16], t.CiphertextSize)
+	return bs
+}
+
+func parseTrailer(bs []byte) trailer {
+	return trailer{
+		PlaintextSize:  binary.BigEndian.Uint64(bs[0:8]),
+		CiphertextSize: binary.BigEndian.Uint64(bs[8:16]),
+	}
 }
 
 func writeHeader(w io.Writer, h *fileHeader) error {
@@ -39,29 +144,58 @@ func writeHeader(w io.Writer, h *fileHeader) error {
 	return err
 }
 
+// HasHeader reports whether data begins with a CRFS header of a version this package
+// can read. It is used to tell streams apart from other formats before calling NewReader.
+func HasHeader(data []byte) bool {
+	if len(data) < len(magic)+1 || !bytes.Equal(data[0:4], magic[:]) {
+		return false
+	}
+	return supportedVersion(data[4])
+}
+
+func supportedVersion(v byte) bool {
+	return v == formatVersion1 || v == formatVersion3
+}
+
+// maxChunks is the number of chunks a stream can hold before nonces would repeat.
+// The top two bits of the counter are reserved for finalChunkFlag and rewrapFlag.
+// Version 1 doesn't use finalChunkFlag, but stays below rewrapFlag so rewrapped
+// streams can't collide with its counters either.
+const maxChunks = 1 << 38
+
 func readHeader(r io.Reader) (*fileHeader, []byte, error) {
-	// readHeader is only called after the caller has already confirmed the CRFS
-	// magic (e.g. Reveal checks the first 4 bytes before delegating here), so
-	// consuming bytes from the reader is safe — we know it's the new format.
-	var fixed [fixedHeaderSize]byte
-	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+	// readHeader consumes bytes from r, so callers which accept other formats
+	// (e.g. cryptfs.FS.Reveal) check HasHeader before delegating here.
+	var prefix [headerPrefixSize]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
 		return nil, nil, fmt.Errorf("reading header: %w", err)
 	}
 
-	if !bytes.Equal(fixed[0:4], magic[:]) {
+	if !bytes.Equal(prefix[0:4], magic[:]) {
 		return nil, nil, errors.New("invalid magic bytes")
 	}
-	if fixed[4] != formatVersion {
-		return nil, nil, fmt.Errorf("unsupported format version: %d", fixed[4])
+	if !supportedVersion(prefix[4]) {
+		return nil, nil, fmt.Errorf("unsupported format version: %d", prefix[4])
 	}
 
 	h := &fileHeader{
-		Version: fixed[4],
-		Flags:   fixed[5],
+		Version: prefix[4],
+		Flags:   prefix[5],
+	}
+	copy(h.NoncePrefix[:], prefix[6:13])
+
+	if h.Version >= formatVersion3 {
+		if err := readHeaderFields(r, h); err != nil {
+			return nil, nil, err
+		}
+		return h, headerBytes(h), nil
 	}
-	copy(h.NoncePrefix[:], fixed[6:13])
 
-	wkLen := binary.BigEndian.Uint16(fixed[13:15])
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	wkLen := binary.BigEndian.Uint16(lenBuf[:])
 	if wkLen > 0 {
 		h.WrappedKey = make([]byte, wkLen)
 		if _, err := io.ReadFull(r, h.WrappedKey); err != nil {
@@ -73,7 +207,117 @@ func readHeader(r io.Reader) (*fileHeader, []byte, error) {
 	return h, bs, nil
 }
 
+func readHeaderFields(r io.Reader, h *fileHeader) error {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	fieldsLen := binary.BigEndian.Uint32(lenBuf[:])
+	if fieldsLen > maxHeaderFieldsSize {
+		return fmt.Errorf("header fields too large: %d bytes", fieldsLen)
+	}
+
+	fields := make([]byte, fieldsLen)
+	if _, err := io.ReadFull(r, fields); err != nil {
+		return fmt.Errorf("reading header fields: %w", err)
+	}
+
+	seen := make(map[byte]bool)
+	for len(fields) > 0 {
+		if len(fields) < 3 {
+			return errors.New("truncated header field")
+		}
+		typ := fields[0]
+		length := int(binary.BigEndian.Uint16(fields[1:3]))
+		if len(fields) < 3+length {
+			return fmt.Errorf("truncated header field 0x%02x", typ)
+		}
+		value := fields[3 : 3+length]
+		fields = fields[3+length:]
+
+		if seen[typ] && typ != fieldStanza && typ != fieldMetadata {
+			return fmt.Errorf("duplicate header field 0x%02x", typ)
+		}
+		seen[typ] = true
+
+		switch typ {
+		case fieldChunkSize:
+			if length != 4 {
+				return errors.New("invalid chunk size field")
+			}
+			h.ChunkSize = binary.BigEndian.Uint32(value)
+		case fieldWrappedKey:
+			h.WrappedKey = bytes.Clone(value)
+		case fieldStanza:
+			if length < 1 || length < 1+int(value[0])+1 {
+				return errors.New("invalid key stanza field")
+			}
+			idLen := int(value[0])
+			h.Stanzas = append(h.Stanzas, Stanza{
+				ProviderID: string(value[1 : 1+idLen]),
+				WrappedKey: bytes.Clone(value[1+idLen:]),
+			})
+		case fieldMetadata:
+			if length < 1 || length < 1+int(value[0]) || value[0] == 0 {
+				return errors.New("invalid metadata field")
+			}
+			keyLen := int(value[0])
+			key := string(value[1 : 1+keyLen])
+			if _, ok := h.Metadata[key]; ok {
+				return fmt.Errorf("duplicate metadata key %q", key)
+			}
+			if h.Metadata == nil {
+				h.Metadata = make(map[string]string)
+			}
+			h.Metadata[key] = string(value[1+keyLen:])
+		case fieldCipher:
+			if length < 1 {
+				return errors.New("invalid cipher field")
+			}
+			h.Cipher = Cipher(value[0])
+			if !supportedCipher(h.Cipher) {
+				return fmt.Errorf("unsupported cipher %v", h.Cipher)
+			}
+			want := 1
+			if h.Cipher == XChaCha20Poly1305 {
+				want += xNoncePrefixSize
+			}
+			if length != want {
+				return fmt.Errorf("invalid %v cipher field", h.Cipher)
+			}
+			copy(h.XNoncePrefix[:], value[1:])
+		case fieldCodec:
+			if length != 1 {
+				return errors.New("invalid compression codec field")
+			}
+			h.Codec = Codec(value[0])
+			if h.Codec == CodecNone || !supportedCodec(h.Codec) {
+				return fmt.Errorf("unsupported compression codec %v", h.Codec)
+			}
+		default:
+			// Fields are authenticated, so a writer must have meant something by
+			// an unknown field. Refuse to guess.
+			return fmt.Errorf("unknown header field 0x%02x", typ)
+		}
+	}
+
+	if h.ChunkSize == 0 {
+		return errors.New("missing chunk size")
+	}
+	if h.Flags&flagGzip != 0 {
+		return errors.New("gzip flag is only used by format version 1")
+	}
+	if seen[fieldWrappedKey] && seen[fieldStanza] {
+		return errors.New("header has both a wrapped key and key stanzas")
+	}
+	return nil
+}
+
 func headerBytes(h *fileHeader) []byte {
+	if h.Version >= formatVersion3 {
+		return fieldsHeaderBytes(h)
+	}
+
 	wkLen := len(h.WrappedKey)
 	bs := make([]byte, fixedHeaderSize+wkLen)
 
@@ -90,6 +334,101 @@ func headerBytes(h *fileHeader) []byte {
 	return bs
 }
 
+func fieldsHeaderBytes(h *fileHeader) []byte {
+	bs := make([]byte, fieldsHeaderSize, fieldsHeaderSize+7+3+len(h.WrappedKey))
+	copy(bs[0:4], magic[:])
+	bs[4] = h.Version
+	bs[5] = h.Flags
+	copy(bs[6:13], h.NoncePrefix[:])
+
+	var chunkSize [4]byte
+	binary.BigEndian.PutUint32(chunkSize[:], h.ChunkSize)
+	bs = appendHeaderField(bs, fieldChunkSize, chunkSize[:])
+	if h.Cipher != AESGCM {
+		value := []byte{byte(h.Cipher)}
+		if h.Cipher == XChaCha20Poly1305 {
+			value = append(value, h.XNoncePrefix[:]...)
+		}
+		bs = appendHeaderField(bs, fieldCipher, value)
+	}
+	if h.Codec != CodecNone {
+		bs = appendHeaderField(bs, fieldCodec, []byte{byte(h.Codec)})
+	}
+	if len(h.WrappedKey) > 0 {
+		bs = appendHeaderField(bs, fieldWrappedKey, h.WrappedKey)
+	}
+	for _, st := range h.Stanzas {
+		value := make([]byte, 0, 1+len(st.ProviderID)+len(st.WrappedKey))
+		value = append(value, byte(len(st.ProviderID))) //nolint:gosec // checked by validateHeader
+		value = append(value, st.ProviderID...)
+		value = append(value, st.WrappedKey...)
+		bs = appendHeaderField(bs, fieldStanza, value)
+	}
+	for _, key := range slices.Sorted(maps.Keys(h.Metadata)) {
+		value := make([]byte, 0, 1+len(key)+len(h.Metadata[key]))
+		value = append(value, byte(len(key))) //nolint:gosec // checked by validateHeader
+		value = append(value, key...)
+		value = append(value, h.Metadata[key]...)
+		bs = appendHeaderField(bs, fieldMetadata, value)
+	}
+
+	binary.BigEndian.PutUint32(bs[13:17], uint32(len(bs)-fieldsHeaderSize)) //nolint:gosec // fields are bounded by their 2-byte lengths
+	return bs
+}
+
+func appendHeaderField(bs []byte, typ byte, value []byte) []byte {
+	bs = append(bs, typ)
+	bs = binary.BigEndian.AppendUint16(bs, uint16(len(value))) //nolint:gosec // checked by validateHeader
+	return append(bs, value...)
+}
+
+// validateHeader checks that h can be serialized.
+func validateHeader(h *fileHeader) error {
+	if len(h.WrappedKey) > math.MaxUint16 {
+		return fmt.Errorf("wrapped key too large: %d bytes", len(h.WrappedKey))
+	}
+	for i, st := range h.Stanzas {
+		if len(st.ProviderID) > math.MaxUint8 {
+			return fmt.Errorf("provider ID of key stanza %d longer than %d bytes", i, math.MaxUint8)
+		}
+		if len(st.WrappedKey) == 0 {
+			return fmt.Errorf("key stanza %d has no wrapped key", i)
+		}
+		if 1+len(st.ProviderID)+len(st.WrappedKey) > math.MaxUint16 {
+			return fmt.Errorf("key stanza %d too large", i)
+		}
+	}
+	for key, value := range h.Metadata {
+		if key == "" {
+			return errors.New("empty metadata key")
+		}
+		if len(key) > math.MaxUint8 {
+			return fmt.Errorf("metadata key %q longer than %d bytes", key, math.MaxUint8)
+		}
+		if 1+len(key)+len(value) > math.MaxUint16 {
+			return fmt.Errorf("metadata value of %q too large", key)
+		}
+	}
+	if !supportedCipher(h.Cipher) {
+		return fmt.Errorf("unsupported cipher %v", h.Cipher)
+	}
+	if h.Cipher != AESGCM && h.Version < formatVersion3 {
+		return fmt.Errorf("%v requires format version %d", h.Cipher, formatVersion3)
+	}
+	if !supportedCodec(h.Codec) {
+		return fmt.Errorf("unsupported compression codec %v", h.Codec)
+	}
+	if h.Codec != CodecNone && h.Version < formatVersion3 {
+		return fmt.Errorf("%v compression requires format version %d", h.Codec, formatVersion3)
+	}
+	if h.Version >= formatVersion3 {
+		if n := len(headerBytes(h)) - fieldsHeaderSize; n > maxHeaderFieldsSize {
+			return fmt.Errorf("header fields too large: %d bytes", n)
+		}
+	}
+	return nil
+}
+
 func buildNonce(prefix [noncePrefixSize]byte, counter uint64) [nonceSize]byte {
 	var nonce [nonceSize]byte
 	copy(nonce[:noncePrefixSize], prefix[:])
@@ -105,3 +444,28 @@ func buildNonce(prefix [noncePrefixSize]byte, counter uint64) [nonceSize]byte {
 	nonce[11] = byte(counter)      //nolint:gosec // intentional truncation to extract byte
 	return nonce
 }
+
+// buildFinalNonce returns the nonce for the last chunk of a stream, which has finalChunkFlag set.
+func buildFinalNonce(prefix [noncePrefixSize]byte, counter uint64) [nonceSize]byte {
+	nonce := buildNonce(prefix, counter)
+	nonce[noncePrefixSize] |= finalChunkFlag
+	return nonce
+}
+
+// buildRewrapNonce returns the nonce of chunk 0 after Rewrap, which has rewrapFlag
+// set and epoch in place of the counter.
+func buildRewrapNonce(prefix [noncePrefixSize]byte, epoch uint64) [nonceSize]byte {
+	nonce := buildNonce(prefix, epoch&rewrapEpochMask)
+	nonce[noncePrefixSize] |= rewrapFlag
+	return nonce
+}
+
+// clearRewrapEpoch returns the nonce of chunk 0 without the rewrapFlag and epoch set
+// by Rewrap, so it can be compared with the nonce of counter 0. finalChunkFlag is kept.
+func clearRewrapEpoch(nonce [nonceSize]byte) [nonceSize]byte {
+	if nonce[noncePrefixSize]&rewrapFlag != 0 {
+		nonce[noncePrefixSize] &= finalChunkFlag
+		clear(nonce[noncePrefixSize+1:])
+	}
+	return nonce
+}
@@ -3,6 +3,7 @@ package stream
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -15,7 +16,7 @@ func TestHeaderRoundTrip(t *testing.T) {
 		require.NoError(t, err)
 
 		h := &fileHeader{
-			Version:     formatVersion,
+			Version:     formatVersion1,
 			Flags:       flagGzip,
 			NoncePrefix: prefix,
 		}
@@ -42,7 +43,7 @@ func TestHeaderRoundTrip(t *testing.T) {
 		wrappedKey := []byte("vault:v1:someciphertext==")
 
 		h := &fileHeader{
-			Version:     formatVersion,
+			Version:     formatVersion1,
 			Flags:       0x00,
 			NoncePrefix: prefix,
 			WrappedKey:  wrappedKey,
@@ -61,11 +62,37 @@ func TestHeaderRoundTrip(t *testing.T) {
 		require.Equal(t, wrappedKey, got.WrappedKey)
 		require.Equal(t, fixedHeaderSize+len(wrappedKey), len(aad))
 	})
+
+	t.Run("header fields", func(t *testing.T) {
+		var prefix [noncePrefixSize]byte
+		_, err := rand.Read(prefix[:])
+		require.NoError(t, err)
+
+		wrappedKey := []byte("vault:v1:someciphertext==")
+
+		h := &fileHeader{
+			Version:     formatVersion3,
+			NoncePrefix: prefix,
+			WrappedKey:  wrappedKey,
+			ChunkSize:   1024,
+			Codec:       CodecGzip,
+		}
+
+		var buf bytes.Buffer
+		err = writeHeader(&buf, h)
+		require.NoError(t, err)
+		require.Equal(t, fieldsHeaderSize+7+4+3+len(wrappedKey), buf.Len())
+
+		got, aad, err := readHeader(&buf)
+		require.NoError(t, err)
+		require.Equal(t, h, got)
+		require.Equal(t, fieldsHeaderSize+7+4+3+len(wrappedKey), len(aad))
+	})
 }
 
 func TestHeaderBytes(t *testing.T) {
 	h := &fileHeader{
-		Version: formatVersion,
+		Version: formatVersion1,
 		Flags:   flagGzip,
 	}
 
@@ -74,7 +101,7 @@ func TestHeaderBytes(t *testing.T) {
 	require.Equal(t, byte('R'), bs[1])
 	require.Equal(t, byte('F'), bs[2])
 	require.Equal(t, byte('S'), bs[3])
-	require.Equal(t, byte(formatVersion), bs[4])
+	require.Equal(t, byte(formatVersion1), bs[4])
 	require.Equal(t, byte(flagGzip), bs[5])
 }
 
@@ -94,21 +121,152 @@ func TestReadHeaderErrors(t *testing.T) {
 	t.Run("bad version", func(t *testing.T) {
 		bs := make([]byte, fixedHeaderSize)
 		copy(bs[0:4], magic[:])
-		bs[4] = 0xFF
-		_, _, err := readHeader(bytes.NewReader(bs))
-		require.ErrorContains(t, err, "unsupported format version")
+		for _, version := range []byte{0x02, 0xFF} {
+			bs[4] = version
+			_, _, err := readHeader(bytes.NewReader(bs))
+			require.ErrorContains(t, err, "unsupported format version")
+		}
 	})
 
 	t.Run("truncated wrapped key", func(t *testing.T) {
 		bs := make([]byte, fixedHeaderSize)
 		copy(bs[0:4], magic[:])
-		bs[4] = formatVersion
+		bs[4] = formatVersion1
 		// wrapped key length = 100, but no data follows
 		bs[13] = 0
 		bs[14] = 100
 		_, _, err := readHeader(bytes.NewReader(bs))
 		require.Error(t, err)
 	})
+
+	fieldsHeader := func(fields ...[]byte) []byte {
+		bs := make([]byte, fieldsHeaderSize)
+		copy(bs[0:4], magic[:])
+		bs[4] = formatVersion3
+		for _, f := range fields {
+			bs = append(bs, f...)
+		}
+		binary.BigEndian.PutUint32(bs[13:17], uint32(len(bs)-fieldsHeaderSize))
+		return bs
+	}
+	chunkSize := []byte{fieldChunkSize, 0, 4, 0, 0, 1, 0}
+
+	t.Run("fields", func(t *testing.T) {
+		h, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize)))
+		require.NoError(t, err)
+		require.Equal(t, uint32(256), h.ChunkSize)
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{0x7F, 0, 1, 0})))
+		require.ErrorContains(t, err, "unknown header field 0x7f")
+	})
+
+	t.Run("duplicate field", func(t *testing.T) {
+		_, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize, chunkSize)))
+		require.ErrorContains(t, err, "duplicate header field 0x01")
+	})
+
+	t.Run("missing chunk size", func(t *testing.T) {
+		_, _, err := readHeader(bytes.NewReader(fieldsHeader([]byte{fieldWrappedKey, 0, 1, 'k'})))
+		require.ErrorContains(t, err, "missing chunk size")
+	})
+
+	t.Run("key stanzas", func(t *testing.T) {
+		h, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize,
+			[]byte{fieldStanza, 0, 4, 1, 'a', 'k', '1'},
+			[]byte{fieldStanza, 0, 3, 0, 'k', '2'},
+		)))
+		require.NoError(t, err)
+		require.Equal(t, []Stanza{
+			{ProviderID: "a", WrappedKey: []byte("k1")},
+			{ProviderID: "", WrappedKey: []byte("k2")},
+		}, h.Stanzas)
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldStanza, 0, 2, 1, 'a'})))
+		require.ErrorContains(t, err, "invalid key stanza field")
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize,
+			[]byte{fieldWrappedKey, 0, 1, 'k'},
+			[]byte{fieldStanza, 0, 2, 0, 'k'},
+		)))
+		require.ErrorContains(t, err, "both a wrapped key and key stanzas")
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		h, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize,
+			[]byte{fieldMetadata, 0, 4, 1, 'a', 'v', '1'},
+			[]byte{fieldMetadata, 0, 2, 1, 'b'},
+		)))
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"a": "v1", "b": ""}, h.Metadata)
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldMetadata, 0, 2, 0, 'v'})))
+		require.ErrorContains(t, err, "invalid metadata field")
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldMetadata, 0, 2, 2, 'a'})))
+		require.ErrorContains(t, err, "invalid metadata field")
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize,
+			[]byte{fieldMetadata, 0, 3, 1, 'a', '1'},
+			[]byte{fieldMetadata, 0, 3, 1, 'a', '2'},
+		)))
+		require.ErrorContains(t, err, `duplicate metadata key "a"`)
+	})
+
+	t.Run("cipher", func(t *testing.T) {
+		h, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCipher, 0, 1, byte(ChaCha20Poly1305)})))
+		require.NoError(t, err)
+		require.Equal(t, ChaCha20Poly1305, h.Cipher)
+
+		xchacha := append([]byte{fieldCipher, 0, 13, byte(XChaCha20Poly1305)}, bytes.Repeat([]byte{0xAB}, xNoncePrefixSize)...)
+		h, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, xchacha)))
+		require.NoError(t, err)
+		require.Equal(t, XChaCha20Poly1305, h.Cipher)
+		require.Equal(t, byte(0xAB), h.XNoncePrefix[0])
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCipher, 0, 1, byte(XChaCha20Poly1305)})))
+		require.ErrorContains(t, err, "invalid XChaCha20-Poly1305 cipher field")
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCipher, 0, 1, 0x7F})))
+		require.ErrorContains(t, err, "unsupported cipher")
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCipher, 0, 0})))
+		require.ErrorContains(t, err, "invalid cipher field")
+	})
+
+	t.Run("compression codec", func(t *testing.T) {
+		h, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCodec, 0, 1, byte(CodecZstd)})))
+		require.NoError(t, err)
+		require.Equal(t, CodecZstd, h.codec())
+
+		h, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCodec, 0, 1, byte(CodecGzip)})))
+		require.NoError(t, err)
+		require.Equal(t, CodecGzip, h.codec())
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCodec, 0, 1, byte(CodecNone)})))
+		require.ErrorContains(t, err, "unsupported compression codec none")
+
+		_, _, err = readHeader(bytes.NewReader(fieldsHeader(chunkSize, []byte{fieldCodec, 0, 2, byte(CodecZstd), 0})))
+		require.ErrorContains(t, err, "invalid compression codec field")
+
+		bs := fieldsHeader(chunkSize)
+		bs[5] = flagGzip
+		_, _, err = readHeader(bytes.NewReader(bs))
+		require.ErrorContains(t, err, "gzip flag is only used by format version 1")
+	})
+
+	t.Run("truncated field", func(t *testing.T) {
+		_, _, err := readHeader(bytes.NewReader(fieldsHeader(chunkSize[:5])))
+		require.ErrorContains(t, err, "truncated header field")
+	})
+
+	t.Run("fields too large", func(t *testing.T) {
+		bs := fieldsHeader()
+		binary.BigEndian.PutUint32(bs[13:17], maxHeaderFieldsSize+1)
+		_, _, err := readHeader(bytes.NewReader(bs))
+		require.ErrorContains(t, err, "header fields too large")
+	})
 }
 
 func TestBuildNonce(t *testing.T) {
@@ -140,3 +298,15 @@ func TestNonceUniqueness(t *testing.T) {
 		seen[n] = true
 	}
 }
+
+func TestHasHeader(t *testing.T) {
+	h := &fileHeader{
+		Version: formatVersion,
+	}
+	require.True(t, HasHeader(headerBytes(h)))
+
+	require.False(t, HasHeader(nil))
+	require.False(t, HasHeader([]byte("CRFS")))
+	require.False(t, HasHeader([]byte("CRFSx")))
+	require.False(t, HasHeader([]byte("hello, world")))
+}
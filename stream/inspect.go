@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Info describes a CRFS stream as recorded in its header and trailer.
+type Info struct {
+	Version    int
+	Compressed bool
+	Codec      Codec
+	Cipher     Cipher
+	WrappedKey []byte
+
+	// Stanzas hold the data key wrapped for each recipient of a stream written
+	// with WithRecipients. WrappedKey is empty for these streams.
+	Stanzas []Stanza
+
+	// Metadata is set by WithMetadata.
+	Metadata map[string]string
+
+	// HeaderSize is the number of bytes before the first chunk.
+	HeaderSize int64
+
+	// ChunkSize is the plaintext size of each chunk. It's zero for streams
+	// written before it was recorded (version 1).
+	ChunkSize int
+
+	// PlaintextSize and CiphertextSize are read from the trailer. PlaintextSize is
+	// the size before compression and CiphertextSize the size of the chunks between
+	// the header and end marker. Both are -1 for streams without a trailer
+	// (version 1).
+	PlaintextSize  int64
+	CiphertextSize int64
+}
+
+// Inspect reads the header and trailer of the CRFS stream in r without a key.
+// When r implements io.Seeker the trailer is read from the end of the stream,
+// otherwise the chunks are skipped over.
+//
+// Nothing returned by Inspect is authenticated. The header and trailer are verified
+// by NewReader and NewReaderAt when the stream is decrypted.
+func Inspect(r io.Reader) (*Info, error) {
+	h, aad, err := readHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	info := &Info{
+		Version:        int(h.Version),
+		Compressed:     h.codec() != CodecNone,
+		Codec:          h.codec(),
+		Cipher:         h.Cipher,
+		WrappedKey:     h.WrappedKey,
+		Stanzas:        h.Stanzas,
+		Metadata:       h.Metadata,
+		HeaderSize:     int64(len(aad)),
+		ChunkSize:      int(h.ChunkSize),
+		PlaintextSize:  -1,
+		CiphertextSize: -1,
+	}
+	if h.Version < formatVersion3 {
+		return info, nil
+	}
+
+	var t trailer
+	if s, ok := r.(io.Seeker); ok {
+		t, err = seekTrailer(s, r, info.HeaderSize)
+	} else {
+		t, err = scanTrailer(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.PlaintextSize > 1<<62 || t.CiphertextSize > 1<<62 {
+		return nil, errors.New("invalid trailer sizes")
+	}
+	info.PlaintextSize = int64(t.PlaintextSize)   //nolint:gosec // checked above
+	info.CiphertextSize = int64(t.CiphertextSize) //nolint:gosec // checked above
+	return info, nil
+}
+
+// seekTrailer reads the trailer from the end of the stream. The header has
+// already been read from r.
+func seekTrailer(s io.Seeker, r io.Reader, headerSize int64) (trailer, error) {
+	start, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return trailer{}, fmt.Errorf("seeking: %w", err)
+	}
+	end, err := s.Seek(-(4 + trailerSize), io.SeekEnd)
+	if err != nil {
+		return trailer{}, fmt.Errorf("seeking to trailer: %w", err)
+	}
+
+	t, err := readTrailer(r)
+	if err != nil {
+		return trailer{}, err
+	}
+	// The stream may not begin at offset 0 of s
+	if t.CiphertextSize != uint64(end-start) { //nolint:gosec // end is after start
+		return trailer{}, fmt.Errorf("trailer records %d bytes of chunks but found %d", t.CiphertextSize, end-start)
+	}
+	return t, nil
+}
+
+// scanTrailer skips over the chunks in r to read the trailer.
+func scanTrailer(r io.Reader) (trailer, error) {
+	var read uint64
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return trailer{}, fmt.Errorf("reading chunk length: %w", err)
+		}
+		chunkLen := binary.BigEndian.Uint32(lenBuf[:])
+		if chunkLen == 0 {
+			break
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(chunkLen)); err != nil {
+			return trailer{}, fmt.Errorf("reading chunk data: %w", err)
+		}
+		read += 4 + uint64(chunkLen)
+	}
+
+	var tb [trailerSize]byte
+	if _, err := io.ReadFull(r, tb[:]); err != nil {
+		return trailer{}, fmt.Errorf("reading trailer: %w", err)
+	}
+	t := parseTrailer(tb[:])
+	if t.CiphertextSize != read {
+		return trailer{}, fmt.Errorf("trailer records %d bytes of chunks but found %d", t.CiphertextSize, read)
+	}
+	return t, nil
+}
+
+// readTrailer reads the end marker and trailer.
+func readTrailer(r io.Reader) (trailer, error) {
+	var tb [4 + trailerSize]byte
+	if _, err := io.ReadFull(r, tb[:]); err != nil {
+		return trailer{}, fmt.Errorf("reading trailer: %w", err)
+	}
+	if binary.BigEndian.Uint32(tb[:4]) != 0 {
+		return trailer{}, errors.New("missing end marker")
+	}
+	return parseTrailer(tb[4:]), nil
+}
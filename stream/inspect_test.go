@@ -0,0 +1,92 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	key := []byte("1234567890123456")
+	kp := NewStaticKeyProvider(key)
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100)
+
+	write := func(t *testing.T, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	t.Run("seeker", func(t *testing.T) {
+		data := write(t, WithChunkSize(1000))
+
+		info, err := Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, formatVersion, info.Version)
+		require.False(t, info.Compressed)
+		require.Equal(t, 1000, info.ChunkSize)
+		require.Equal(t, int64(len(original)), info.PlaintextSize)
+		require.Equal(t, int64(len(data))-info.HeaderSize-4-trailerSize, info.CiphertextSize)
+	})
+
+	t.Run("seeker at offset", func(t *testing.T) {
+		data := write(t)
+
+		r := bytes.NewReader(append([]byte("prefix"), data...))
+		_, err := r.Seek(6, io.SeekStart)
+		require.NoError(t, err)
+
+		info, err := Inspect(r)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(original)), info.PlaintextSize)
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		data := write(t, WithCompression(), WithChunkSize(100))
+
+		info, err := Inspect(iotest.OneByteReader(bytes.NewReader(data)))
+		require.NoError(t, err)
+		require.True(t, info.Compressed)
+		require.Equal(t, 100, info.ChunkSize)
+		require.Equal(t, int64(len(original)), info.PlaintextSize)
+		require.Less(t, info.CiphertextSize, int64(len(original)))
+	})
+
+	t.Run("mismatched trailer", func(t *testing.T) {
+		data := write(t, WithChunkSize(1000))
+		header, chunks, tail := splitChunks(t, data)
+		data = joinChunks(header, chunks[1:], tail)
+
+		_, err := Inspect(bytes.NewReader(data))
+		require.ErrorContains(t, err, "trailer records")
+
+		_, err = Inspect(iotest.OneByteReader(bytes.NewReader(data)))
+		require.ErrorContains(t, err, "trailer records")
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		buf := writeVersionedTestData(t, formatVersion1, key, original, true, 0)
+
+		info, err := Inspect(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, formatVersion1, info.Version)
+		require.True(t, info.Compressed)
+		require.Equal(t, 0, info.ChunkSize)
+		require.Equal(t, int64(-1), info.PlaintextSize)
+		require.Equal(t, int64(-1), info.CiphertextSize)
+	})
+
+	t.Run("not a stream", func(t *testing.T) {
+		_, err := Inspect(bytes.NewReader([]byte("plaintext")))
+		require.Error(t, err)
+	})
+}
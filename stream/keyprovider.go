@@ -1,7 +1,9 @@
 package stream
 
 import (
+	"context"
 	"errors"
+	"fmt"
 )
 
 // DataKey holds a plaintext AES key and its wrapped (encrypted) form.
@@ -18,6 +20,83 @@ type KeyProvider interface {
 	UnwrapKey(wrappedKey []byte) ([]byte, error)
 }
 
+// ContextKeyProvider is implemented by a KeyProvider which can honor cancellation
+// and deadlines, such as one making network calls. NewWriterContext and
+// NewReaderContext use these methods when they're available.
+type ContextKeyProvider interface {
+	KeyProvider
+
+	GenerateKeyContext(ctx context.Context) (*DataKey, error)
+	UnwrapKeyContext(ctx context.Context, wrappedKey []byte) ([]byte, error)
+}
+
+// KeyWrapper is implemented by a KeyProvider which can wrap an existing data key.
+// Providers passed to WithRecipients must implement it so each recipient gets its
+// own wrapped copy of the data key.
+type KeyWrapper interface {
+	WrapKey(plaintext []byte) ([]byte, error)
+}
+
+// ContextKeyWrapper is like KeyWrapper but honors cancellation and deadlines.
+type ContextKeyWrapper interface {
+	WrapKeyContext(ctx context.Context, plaintext []byte) ([]byte, error)
+}
+
+// ProviderIdentifier is implemented by a KeyProvider which labels the key stanzas it
+// writes. NewReader only tries the stanzas with the reading provider's label, while
+// providers without one try every stanza.
+type ProviderIdentifier interface {
+	ProviderID() string
+}
+
+// staticKeyHolder is implemented by a KeyProvider which holds the key of streams
+// written without a wrapped key, such as by NewStaticKeyProvider. NewReader uses it
+// instead of GenerateKey for those streams.
+type staticKeyHolder interface {
+	staticKey(ctx context.Context) ([]byte, error)
+}
+
+func providerID(kp KeyProvider) string {
+	if pi, ok := kp.(ProviderIdentifier); ok {
+		return pi.ProviderID()
+	}
+	return ""
+}
+
+func wrapKey(ctx context.Context, kp KeyProvider, plaintext []byte) ([]byte, error) {
+	if ckw, ok := kp.(ContextKeyWrapper); ok {
+		return ckw.WrapKeyContext(ctx, plaintext)
+	}
+	kw, ok := kp.(KeyWrapper)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement KeyWrapper", kp)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return kw.WrapKey(plaintext)
+}
+
+func generateKey(ctx context.Context, kp KeyProvider) (*DataKey, error) {
+	if ckp, ok := kp.(ContextKeyProvider); ok {
+		return ckp.GenerateKeyContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return kp.GenerateKey()
+}
+
+func unwrapKey(ctx context.Context, kp KeyProvider, wrappedKey []byte) ([]byte, error) {
+	if ckp, ok := kp.(ContextKeyProvider); ok {
+		return ckp.UnwrapKeyContext(ctx, wrappedKey)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return kp.UnwrapKey(wrappedKey)
+}
+
 // NewStaticKeyProvider returns a KeyProvider that always uses the given AES key.
 func NewStaticKeyProvider(key []byte) KeyProvider {
 	cp := make([]byte, len(key))
@@ -33,6 +112,10 @@ func (p *staticKeyProvider) GenerateKey() (*DataKey, error) {
 	return &DataKey{Plaintext: p.key}, nil
 }
 
+func (p *staticKeyProvider) staticKey(context.Context) ([]byte, error) {
+	return p.key, nil
+}
+
 func (p *staticKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
 	if len(wrappedKey) > 0 {
 		return nil, errors.New("static key provider cannot unwrap keys")
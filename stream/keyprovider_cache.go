@@ -0,0 +1,290 @@
+package stream
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CachingKeyProvider caches the data keys unwrapped by another KeyProvider, such as
+// one calling Vault, so opening many streams with the same wrapped key doesn't call
+// it each time. Keys are held in a bounded LRU cache and zeroed when they're evicted.
+// Expired keys are evicted when they're looked up, and the others when a key is added
+// or Stats is called, so an idle cache keeps them until then or until Purge.
+//
+// The cached keys are as sensitive as the provider's master key while they're held.
+type CachingKeyProvider struct {
+	inner      KeyProvider
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	stats   CacheStats
+
+	// Generated data keys are reused when enabled with ReuseDataKeys
+	reuseFiles int
+	reuseAge   time.Duration
+	current    *reusedKey
+}
+
+type cacheEntry struct {
+	wrappedKey string
+	key        []byte
+	expires    time.Time
+}
+
+type reusedKey struct {
+	dk      *DataKey
+	uses    int
+	created time.Time
+}
+
+// CacheStats counts the unwraps of a CachingKeyProvider.
+type CacheStats struct {
+	Hits      uint64 // unwraps answered from the cache
+	Misses    uint64 // unwraps passed to the inner provider
+	Evictions uint64 // keys removed for space or after their TTL, but not by Purge
+	Entries   int    // keys currently cached
+}
+
+// CacheOption configures a CachingKeyProvider.
+type CacheOption func(*CachingKeyProvider)
+
+// ReuseDataKeys makes GenerateKey return the same data key for up to maxFiles streams
+// or for maxAge, whichever ends first, before generating another. A limit of zero
+// isn't applied, and reuse is off when both are zero.
+//
+// Streams sharing a data key are told apart by their random 7-byte nonce prefix,
+// so keep the limits low, or write with XChaCha20Poly1305 whose nonces have 19
+// random bytes per stream.
+func ReuseDataKeys(maxFiles int, maxAge time.Duration) CacheOption {
+	return func(p *CachingKeyProvider) {
+		p.reuseFiles = max(maxFiles, 0)
+		p.reuseAge = max(maxAge, 0)
+	}
+}
+
+// NewCachingKeyProvider returns a CachingKeyProvider which caches up to maxEntries
+// data keys unwrapped by inner for ttl. A ttl of zero keeps keys until they're
+// evicted for space, and a maxEntries of zero or less caches nothing.
+//
+// Keys generated by inner are cached too, so streams are read back without calling
+// it. The provider implements ContextKeyProvider, and KeyWrapper and
+// ProviderIdentifier by calling inner.
+func NewCachingKeyProvider(inner KeyProvider, ttl time.Duration, maxEntries int, opts ...CacheOption) *CachingKeyProvider {
+	p := &CachingKeyProvider{
+		inner:      inner,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	for _, fn := range opts {
+		fn(p)
+	}
+	return p
+}
+
+// Stats returns the cache's counters.
+func (p *CachingKeyProvider) Stats() CacheStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sweep()
+	stats := p.stats
+	stats.Entries = p.lru.Len()
+	return stats
+}
+
+// Purge zeroes and removes every cached key, including a reused data key.
+func (p *CachingKeyProvider) Purge() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.lru.Len() > 0 {
+		p.remove(p.lru.Back())
+	}
+	if p.current != nil {
+		clear(p.current.dk.Plaintext)
+		p.current = nil
+	}
+}
+
+func (p *CachingKeyProvider) staticKey(ctx context.Context) ([]byte, error) {
+	if skh, ok := p.inner.(staticKeyHolder); ok {
+		return skh.staticKey(ctx)
+	}
+	dk, err := generateKey(ctx, p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return dk.Plaintext, nil
+}
+
+func (p *CachingKeyProvider) ProviderID() string {
+	return providerID(p.inner)
+}
+
+func (p *CachingKeyProvider) GenerateKey() (*DataKey, error) {
+	return p.GenerateKeyContext(context.Background())
+}
+
+func (p *CachingKeyProvider) GenerateKeyContext(ctx context.Context) (*DataKey, error) {
+	if p.reuseFiles > 0 || p.reuseAge > 0 {
+		if dk := p.reuse(); dk != nil {
+			return dk, nil
+		}
+	}
+
+	dk, err := generateKey(ctx, p.inner)
+	if err != nil {
+		return nil, err
+	}
+	if len(dk.WrappedKey) > 0 {
+		p.add(dk.WrappedKey, dk.Plaintext)
+	}
+
+	if p.reuseFiles > 0 || p.reuseAge > 0 {
+		p.mu.Lock()
+		if p.current != nil {
+			clear(p.current.dk.Plaintext)
+		}
+		p.current = &reusedKey{dk: copyDataKey(dk), uses: 1, created: p.now()}
+		p.mu.Unlock()
+	}
+	return dk, nil
+}
+
+// reuse returns a copy of the current data key while it's within the reuse limits.
+func (p *CachingKeyProvider) reuse() *DataKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.current
+	if c == nil {
+		return nil
+	}
+	if (p.reuseFiles > 0 && c.uses >= p.reuseFiles) || (p.reuseAge > 0 && p.now().Sub(c.created) >= p.reuseAge) {
+		clear(c.dk.Plaintext)
+		p.current = nil
+		return nil
+	}
+	c.uses++
+	return copyDataKey(c.dk)
+}
+
+func (p *CachingKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	return p.WrapKeyContext(context.Background(), plaintext)
+}
+
+func (p *CachingKeyProvider) WrapKeyContext(ctx context.Context, plaintext []byte) ([]byte, error) {
+	return wrapKey(ctx, p.inner, plaintext)
+}
+
+func (p *CachingKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	return p.UnwrapKeyContext(context.Background(), wrappedKey)
+}
+
+func (p *CachingKeyProvider) UnwrapKeyContext(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	if key := p.get(wrappedKey); key != nil {
+		return key, nil
+	}
+
+	// The lock isn't held while calling the inner provider, so concurrent misses
+	// for the same key may each call it.
+	key, err := unwrapKey(ctx, p.inner, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		p.add(wrappedKey, key)
+	}
+	return key, nil
+}
+
+// get returns a copy of the cached key for wrappedKey, or nil on a miss.
+func (p *CachingKeyProvider) get(wrappedKey []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	el, ok := p.entries[string(wrappedKey)]
+	if !ok {
+		p.stats.Misses++
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if p.expired(e) {
+		p.remove(el)
+		p.stats.Evictions++
+		p.stats.Misses++
+		return nil
+	}
+	p.lru.MoveToFront(el)
+	p.stats.Hits++
+	return bytes.Clone(e.key)
+}
+
+// add caches a copy of key, evicting the least recently used keys when full.
+func (p *CachingKeyProvider) add(wrappedKey, key []byte) {
+	if p.maxEntries <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if el, ok := p.entries[string(wrappedKey)]; ok {
+		p.remove(el)
+	}
+	p.sweep()
+	for p.lru.Len() >= p.maxEntries {
+		p.remove(p.lru.Back())
+		p.stats.Evictions++
+	}
+	e := &cacheEntry{
+		wrappedKey: string(wrappedKey),
+		key:        bytes.Clone(key),
+		expires:    p.now().Add(p.ttl),
+	}
+	p.entries[e.wrappedKey] = p.lru.PushFront(e)
+}
+
+// expired reports whether e has outlived the TTL.
+func (p *CachingKeyProvider) expired(e *cacheEntry) bool {
+	return p.ttl > 0 && !p.now().Before(e.expires)
+}
+
+// sweep evicts every expired key. The lock must be held.
+func (p *CachingKeyProvider) sweep() {
+	if p.ttl <= 0 {
+		return
+	}
+	for el := p.lru.Front(); el != nil; {
+		next := el.Next()
+		if p.expired(el.Value.(*cacheEntry)) {
+			p.remove(el)
+			p.stats.Evictions++
+		}
+		el = next
+	}
+}
+
+// remove zeroes and removes the key of el. The lock must be held.
+func (p *CachingKeyProvider) remove(el *list.Element) {
+	e := p.lru.Remove(el).(*cacheEntry)
+	delete(p.entries, e.wrappedKey)
+	clear(e.key)
+}
+
+func copyDataKey(dk *DataKey) *DataKey {
+	return &DataKey{
+		Plaintext:  bytes.Clone(dk.Plaintext),
+		WrappedKey: bytes.Clone(dk.WrappedKey),
+	}
+}
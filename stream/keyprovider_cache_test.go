@@ -0,0 +1,228 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingKeyProvider(t *testing.T) {
+	original := []byte("The quick brown fox jumps over the lazy dog")
+
+	write := func(t *testing.T, kp KeyProvider) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	read := func(t *testing.T, kp KeyProvider, data []byte) {
+		t.Helper()
+
+		r, err := NewReader(bytes.NewReader(data), kp)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	}
+	clock := func(p *CachingKeyProvider) *time.Time {
+		now := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+		p.now = func() time.Time { return now }
+		return &now
+	}
+
+	t.Run("hits and misses", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		streams := [][]byte{write(t, inner), write(t, inner)}
+
+		kp := NewCachingKeyProvider(inner, time.Minute, 10)
+		require.Equal(t, "inner", kp.ProviderID())
+		for range 3 {
+			for _, data := range streams {
+				read(t, kp, data)
+			}
+		}
+		require.Equal(t, 2, inner.unwraps)
+		require.Equal(t, CacheStats{Hits: 4, Misses: 2, Entries: 2}, kp.Stats())
+
+		// Generated keys are cached, so new streams are read without unwrapping
+		read(t, kp, write(t, kp))
+		require.Equal(t, 2, inner.unwraps)
+		require.Equal(t, uint64(5), kp.Stats().Hits)
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		data := write(t, inner)
+
+		kp := NewCachingKeyProvider(inner, time.Minute, 10)
+		now := clock(kp)
+
+		read(t, kp, data)
+		*now = now.Add(59 * time.Second)
+		read(t, kp, data)
+		require.Equal(t, 1, inner.unwraps)
+
+		*now = now.Add(time.Second)
+		read(t, kp, data)
+		require.Equal(t, 2, inner.unwraps)
+		require.Equal(t, CacheStats{Hits: 1, Misses: 2, Evictions: 1, Entries: 1}, kp.Stats())
+
+		// Keys which aren't looked up again are swept once expired
+		*now = now.Add(time.Minute)
+		require.Equal(t, CacheStats{Hits: 1, Misses: 2, Evictions: 2, Entries: 0}, kp.Stats())
+		require.Zero(t, kp.lru.Len())
+	})
+
+	t.Run("LRU", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		a, b, c := write(t, inner), write(t, inner), write(t, inner)
+
+		kp := NewCachingKeyProvider(inner, 0, 2)
+		read(t, kp, a)
+		read(t, kp, b)
+		read(t, kp, a) // b is now the least recently used
+		read(t, kp, c)
+		require.Equal(t, 3, inner.unwraps)
+
+		read(t, kp, a)
+		require.Equal(t, 3, inner.unwraps)
+		read(t, kp, b)
+		require.Equal(t, 4, inner.unwraps)
+		require.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2, Entries: 2}, kp.Stats())
+	})
+
+	t.Run("evicted keys are zeroed", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		kp := NewCachingKeyProvider(inner, 0, 1)
+
+		dk, err := kp.GenerateKey()
+		require.NoError(t, err)
+		cached := kp.lru.Front().Value.(*cacheEntry).key
+		require.Equal(t, dk.Plaintext, cached)
+
+		// Unwrapped keys are copies of the cached key
+		key, err := kp.UnwrapKey(dk.WrappedKey)
+		require.NoError(t, err)
+		key[0] ^= 0xFF
+		require.Equal(t, dk.Plaintext, cached)
+
+		_, err = kp.GenerateKey()
+		require.NoError(t, err)
+		require.Equal(t, make([]byte, len(cached)), cached)
+
+		kp.Purge()
+		require.Zero(t, kp.Stats().Entries)
+	})
+
+	t.Run("Purge isn't an eviction", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		kp := NewCachingKeyProvider(inner, 0, 10)
+
+		dk, err := kp.GenerateKey()
+		require.NoError(t, err)
+		kp.add(dk.WrappedKey, dk.Plaintext) // replacing a key isn't either
+		kp.Purge()
+		require.Equal(t, CacheStats{}, kp.Stats())
+	})
+
+	t.Run("no entries", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		data := write(t, inner)
+
+		kp := NewCachingKeyProvider(inner, time.Minute, 0)
+		read(t, kp, data)
+		read(t, kp, data)
+		require.Equal(t, 2, inner.unwraps)
+	})
+
+	t.Run("errors aren't cached", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		kp := NewCachingKeyProvider(inner, time.Minute, 10)
+
+		_, err := kp.UnwrapKey([]byte("invalid"))
+		require.Error(t, err)
+		_, err = kp.UnwrapKey([]byte("invalid"))
+		require.Error(t, err)
+		require.Equal(t, 2, inner.unwraps)
+		require.Zero(t, kp.Stats().Entries)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		inner := &contextKeyProvider{KeyProvider: newWrappingKeyProvider(t, "inner")}
+		kp := NewCachingKeyProvider(inner, time.Minute, 10)
+
+		ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+		dk, err := kp.GenerateKeyContext(ctx)
+		require.NoError(t, err)
+		kp.Purge()
+		_, err = kp.UnwrapKeyContext(ctx, dk.WrappedKey)
+		require.NoError(t, err)
+		require.Equal(t, []any{"value", "value"}, inner.seen)
+	})
+
+	t.Run("ReuseDataKeys", func(t *testing.T) {
+		inner := newWrappingKeyProvider(t, "inner")
+		kp := NewCachingKeyProvider(inner, time.Minute, 10, ReuseDataKeys(3, time.Hour))
+		now := clock(kp)
+
+		generate := func() *DataKey {
+			dk, err := kp.GenerateKey()
+			require.NoError(t, err)
+			return dk
+		}
+
+		first := generate()
+		require.Equal(t, first, generate())
+		require.Equal(t, first, generate())
+
+		// maxFiles
+		second := generate()
+		require.NotEqual(t, first.WrappedKey, second.WrappedKey)
+		require.Equal(t, second, generate())
+
+		// maxAge
+		*now = now.Add(time.Hour)
+		third := generate()
+		require.NotEqual(t, second.WrappedKey, third.WrappedKey)
+
+		// Returned keys are copies
+		third.Plaintext[0] ^= 0xFF
+		require.NotEqual(t, third.Plaintext, generate().Plaintext)
+
+		// Streams sharing a key are read
+		read(t, kp, write(t, kp))
+		read(t, kp, write(t, kp))
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		inner := NewStaticKeyProvider([]byte("1234567890123456"))
+		kwp, err := NewKeyWrapProvider(bytes.Repeat([]byte("k"), 32))
+		require.NoError(t, err)
+
+		for _, inner := range []KeyProvider{inner, kwp} {
+			kp := NewCachingKeyProvider(inner, time.Minute, 4, ReuseDataKeys(5, 0))
+
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 10 {
+						read(t, kp, write(t, kp))
+					}
+				}()
+			}
+			wg.Wait()
+		}
+	})
+}
@@ -1,6 +1,9 @@
 package stream
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -35,3 +38,65 @@ func TestStaticKeyProvider(t *testing.T) {
 		require.Equal(t, byte('a'), dk.Plaintext[0])
 	})
 }
+
+type ctxKey struct{}
+
+// contextKeyProvider records the context values it is called with.
+type contextKeyProvider struct {
+	KeyProvider
+
+	seen []any
+}
+
+func (p *contextKeyProvider) GenerateKeyContext(ctx context.Context) (*DataKey, error) {
+	p.seen = append(p.seen, ctx.Value(ctxKey{}))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &DataKey{
+		Plaintext:  []byte("1234567890123456"),
+		WrappedKey: []byte("wrapped"),
+	}, nil
+}
+
+func (p *contextKeyProvider) UnwrapKeyContext(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	p.seen = append(p.seen, ctx.Value(ctxKey{}))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return []byte("1234567890123456"), nil
+}
+
+func TestContextKeyProvider(t *testing.T) {
+	kp := &contextKeyProvider{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request-1")
+
+	var buf bytes.Buffer
+	w, err := NewWriterContext(ctx, &buf, kp)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello, world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewReaderContext(ctx, bytes.NewReader(buf.Bytes()), kp)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", string(got))
+	require.Equal(t, []any{"request-1", "request-1"}, kp.seen)
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := NewWriterContext(ctx, io.Discard, kp)
+		require.ErrorIs(t, err, context.Canceled)
+
+		_, err = NewReaderContext(ctx, bytes.NewReader(buf.Bytes()), kp)
+		require.ErrorIs(t, err, context.Canceled)
+
+		// Providers without context support still observe cancellation
+		_, err = NewWriterContext(ctx, io.Discard, NewStaticKeyProvider([]byte("1234567890123456")))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
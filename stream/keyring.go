@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// keyringPrefix starts the wrapped keys of a Keyring, followed by the key ID, a colon
+// and the data key wrapped with AES Key Wrap with Padding.
+const keyringPrefix = "keyring:"
+
+// Keyring is a KeyProvider holding several named AES keys, one of which is active.
+// Each stream gets a random data key wrapped with the active key, and the wrapped key
+// in the header records the ID of the key used. Readers pick the matching key, so
+// streams written before and after rotating the active key are read side by side.
+//
+// Rewrap with the same Keyring as both providers moves a stream to the active key.
+// Streams written with NewStaticKeyProvider have no wrapped key, so they're read with
+// the key given to LegacyKey, and Rewrap moves version 3 streams onto the keyring.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.Block
+	legacy []byte
+}
+
+// KeyringOption configures a Keyring.
+type KeyringOption func(*Keyring)
+
+// LegacyKey reads streams written with NewStaticKeyProvider(key), which have no
+// wrapped key, with the keyring. Without it those streams are rejected.
+func LegacyKey(key []byte) KeyringOption {
+	return func(kr *Keyring) {
+		kr.legacy = bytes.Clone(key)
+	}
+}
+
+// NewKeyring returns a Keyring of the 128, 192 or 256-bit AES keys by ID which
+// writes with the key active. IDs can't be empty or contain a colon.
+func NewKeyring(active string, keys map[string][]byte, opts ...KeyringOption) (*Keyring, error) {
+	kr := &Keyring{
+		active: active,
+		keys:   make(map[string]cipher.Block, len(keys)),
+	}
+	for _, fn := range opts {
+		fn(kr)
+	}
+	if kr.legacy != nil {
+		if _, err := aes.NewCipher(kr.legacy); err != nil {
+			return nil, fmt.Errorf("legacy key: %w", err)
+		}
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		kr.keys[id] = block
+	}
+	if _, ok := kr.keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q not found in keyring", active)
+	}
+	return kr, nil
+}
+
+// ActiveKeyID returns the ID of the key new streams are written with.
+func (kr *Keyring) ActiveKeyID() string {
+	return kr.active
+}
+
+// KeyIDs returns the sorted IDs of every key in the keyring.
+func (kr *Keyring) KeyIDs() []string {
+	return slices.Sorted(maps.Keys(kr.keys))
+}
+
+func (kr *Keyring) ProviderID() string {
+	return "keyring"
+}
+
+func (kr *Keyring) GenerateKey() (*DataKey, error) {
+	kp := &keyWrapProvider{block: kr.keys[kr.active]}
+	dk, err := kp.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	dk.WrappedKey = kr.wrapped(dk.WrappedKey)
+	return dk, nil
+}
+
+func (kr *Keyring) WrapKey(plaintext []byte) ([]byte, error) {
+	wrapped, err := wrapKeyPadded(kr.keys[kr.active], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return kr.wrapped(wrapped), nil
+}
+
+func (kr *Keyring) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	id, wrapped, err := parseKeyringKey(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in keyring", id)
+	}
+	key, err := unwrapKeyPadded(block, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", id, err)
+	}
+	return key, nil
+}
+
+func (kr *Keyring) staticKey(context.Context) ([]byte, error) {
+	if kr.legacy == nil {
+		return nil, errors.New("keyring requires a wrapped key, or a LegacyKey for streams without one")
+	}
+	return kr.legacy, nil
+}
+
+// wrapped prefixes a wrapped key with the active key's ID.
+func (kr *Keyring) wrapped(wrapped []byte) []byte {
+	out := make([]byte, 0, len(keyringPrefix)+len(kr.active)+1+len(wrapped))
+	out = append(out, keyringPrefix...)
+	out = append(out, kr.active...)
+	out = append(out, ':')
+	return append(out, wrapped...)
+}
+
+// KeyringKeyID returns the ID of the key a Keyring wrapped the data key with. It
+// reads the wrapped keys reported by Inspect, such as to find the streams which
+// still use a retired key.
+func KeyringKeyID(wrappedKey []byte) (string, error) {
+	id, _, err := parseKeyringKey(wrappedKey)
+	return id, err
+}
+
+func parseKeyringKey(wrappedKey []byte) (string, []byte, error) {
+	rest, ok := bytes.CutPrefix(wrappedKey, []byte(keyringPrefix))
+	if !ok {
+		if len(wrappedKey) == 0 {
+			return "", nil, errors.New("keyring requires a wrapped key")
+		}
+		return "", nil, errors.New("wrapped key was not written by a keyring")
+	}
+	id, wrapped, ok := bytes.Cut(rest, []byte{':'})
+	if !ok || len(id) == 0 {
+		return "", nil, errors.New("wrapped key has no key ID")
+	}
+	return string(id), wrapped, nil
+}
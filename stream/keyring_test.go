@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	keys := map[string][]byte{
+		"2025": bytes.Repeat([]byte("a"), 16),
+		"2026": bytes.Repeat([]byte("b"), 32),
+	}
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)
+
+	write := func(t *testing.T, kp KeyProvider, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	read := func(kp KeyProvider, data []byte) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(data), kp)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	keyID := func(t *testing.T, data []byte) string {
+		t.Helper()
+
+		info, err := Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		id, err := KeyringKeyID(info.WrappedKey)
+		require.NoError(t, err)
+		return id
+	}
+
+	old, err := NewKeyring("2025", map[string][]byte{"2025": keys["2025"]})
+	require.NoError(t, err)
+	rotated, err := NewKeyring("2026", keys)
+	require.NoError(t, err)
+	require.Equal(t, "2026", rotated.ActiveKeyID())
+	require.Equal(t, []string{"2025", "2026"}, rotated.KeyIDs())
+
+	before := write(t, old)
+	after := write(t, rotated)
+	require.Equal(t, "2025", keyID(t, before))
+	require.Equal(t, "2026", keyID(t, after))
+
+	t.Run("rotation", func(t *testing.T) {
+		for _, data := range [][]byte{before, after} {
+			got, err := read(rotated, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+
+		_, err := read(old, after)
+		require.ErrorContains(t, err, `key "2026" not found in keyring`)
+	})
+
+	t.Run("per-stream data keys", func(t *testing.T) {
+		h1, _, err := readHeader(bytes.NewReader(after))
+		require.NoError(t, err)
+		h2, _, err := readHeader(bytes.NewReader(write(t, rotated)))
+		require.NoError(t, err)
+		require.NotEqual(t, h1.WrappedKey, h2.WrappedKey)
+	})
+
+	t.Run("Rewrap", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Rewrap(bytes.NewReader(before), &buf, rotated, rotated))
+		require.Equal(t, "2026", keyID(t, buf.Bytes()))
+
+		// The old key can be removed once every stream has been rewrapped
+		retired, err := NewKeyring("2026", map[string][]byte{"2026": keys["2026"]})
+		require.NoError(t, err)
+		got, err := read(retired, buf.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("recipients", func(t *testing.T) {
+		kwp, err := NewKeyWrapProvider(bytes.Repeat([]byte("c"), 32))
+		require.NoError(t, err)
+
+		data := write(t, rotated, WithRecipients(kwp))
+		for _, kp := range []KeyProvider{rotated, kwp} {
+			got, err := read(kp, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+	})
+
+	t.Run("legacy key", func(t *testing.T) {
+		legacyKey := bytes.Repeat([]byte("s"), 32)
+		static := write(t, NewStaticKeyProvider(legacyKey))
+
+		_, err := read(rotated, static)
+		require.ErrorContains(t, err, "LegacyKey for streams without one")
+
+		migrating, err := NewKeyring("2026", keys, LegacyKey(legacyKey))
+		require.NoError(t, err)
+		for _, data := range [][]byte{static, before, after} {
+			got, err := read(migrating, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+
+		// Rewrap moves static streams onto the keyring
+		var buf bytes.Buffer
+		require.NoError(t, Rewrap(bytes.NewReader(static), &buf, migrating, migrating))
+		require.Equal(t, "2026", keyID(t, buf.Bytes()))
+		got, err := read(rotated, buf.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+
+		_, err = NewKeyring("2026", keys, LegacyKey([]byte("short")))
+		require.ErrorContains(t, err, "legacy key: crypto/aes: invalid key size")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		swapped, err := NewKeyring("2026", map[string][]byte{"2026": keys["2025"]})
+		require.NoError(t, err)
+		_, err = read(swapped, after)
+		require.ErrorContains(t, err, "key 2026: unwrapping key: integrity check failed")
+	})
+
+	t.Run("foreign wrapped keys", func(t *testing.T) {
+		_, err := rotated.UnwrapKey(nil)
+		require.ErrorContains(t, err, "keyring requires a wrapped key")
+
+		_, err = rotated.UnwrapKey([]byte("vault:v1:abc"))
+		require.ErrorContains(t, err, "not written by a keyring")
+
+		_, err = KeyringKeyID([]byte("keyring:2026"))
+		require.ErrorContains(t, err, "no key ID")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewKeyring("2027", keys)
+		require.ErrorContains(t, err, `active key "2027" not found`)
+
+		_, err = NewKeyring("a:b", map[string][]byte{"a:b": keys["2025"]})
+		require.ErrorContains(t, err, `invalid key ID "a:b"`)
+
+		_, err = NewKeyring("", map[string][]byte{"": keys["2025"]})
+		require.ErrorContains(t, err, `invalid key ID ""`)
+
+		_, err = NewKeyring("short", map[string][]byte{"short": []byte("short")})
+		require.ErrorContains(t, err, "key short: crypto/aes: invalid key size")
+	})
+}
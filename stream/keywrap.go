@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// keyWrapDataKeySize is the size of the AES-256 data keys generated by the key
+// wrap provider, whatever the size of its KEK.
+const keyWrapDataKeySize = 32
+
+// NewKeyWrapProvider returns a KeyProvider which generates a random data key for each
+// stream and stores it in the header wrapped with AES Key Wrap with Padding (RFC 5649)
+// under kek, a 128, 192 or 256-bit AES key encryption key. It gives envelope
+// encryption with a locally held master key.
+//
+// The provider implements KeyWrapper, so it can be a recipient and rewrap streams,
+// and ProviderIdentifier with the ID "aes-kwp".
+func NewKeyWrapProvider(kek []byte) (KeyProvider, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("creating KEK cipher: %w", err)
+	}
+	return &keyWrapProvider{block: block}, nil
+}
+
+type keyWrapProvider struct {
+	block cipher.Block
+}
+
+func (p *keyWrapProvider) ProviderID() string {
+	return "aes-kwp"
+}
+
+func (p *keyWrapProvider) GenerateKey() (*DataKey, error) {
+	key := make([]byte, keyWrapDataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+	wrapped, err := p.WrapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{Plaintext: key, WrappedKey: wrapped}, nil
+}
+
+func (p *keyWrapProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	return wrapKeyPadded(p.block, plaintext)
+}
+
+func (p *keyWrapProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey) == 0 {
+		return nil, errors.New("key wrap provider requires a wrapped key")
+	}
+	return unwrapKeyPadded(p.block, wrappedKey)
+}
+
+// kwpIV is the first half of the alternative initial value of RFC 5649, section 3.
+// The second half is the length of the plaintext.
+var kwpIV = [4]byte{0xA6, 0x59, 0x59, 0xA6}
+
+var errKeyUnwrap = errors.New("unwrapping key: integrity check failed")
+
+// wrapKeyPadded wraps plaintext with AES Key Wrap with Padding (RFC 5649).
+func wrapKeyPadded(block cipher.Block, plaintext []byte) ([]byte, error) {
+	if len(plaintext) == 0 || uint64(len(plaintext)) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid key length %d to wrap", len(plaintext))
+	}
+
+	// The plaintext is padded with zeros to a multiple of 8 bytes and follows
+	// the initial value.
+	n := (len(plaintext) + 7) / 8
+	out := make([]byte, 8+n*8)
+	copy(out, kwpIV[:])
+	binary.BigEndian.PutUint32(out[4:8], uint32(len(plaintext))) //nolint:gosec // checked above
+	copy(out[8:], plaintext)
+
+	if n == 1 {
+		// A single block is encrypted with AES directly
+		block.Encrypt(out, out)
+		return out, nil
+	}
+
+	// The wrapping process W of RFC 3394, section 2.2.1
+	var b [aes.BlockSize]byte
+	for j := range 6 {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[i*8:i*8+8])
+			block.Encrypt(b[:], b[:])
+
+			t := uint64(n*j + i) //nolint:gosec // n is bounded by the plaintext length
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[i*8:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// unwrapKeyPadded unwraps a key wrapped by wrapKeyPadded. Every failure returns
+// the same error so nothing is learned about why the integrity check failed.
+func unwrapKeyPadded(block cipher.Block, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errKeyUnwrap
+	}
+
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	if n == 1 {
+		block.Decrypt(out, out)
+	} else {
+		// The unwrapping process W⁻¹ of RFC 3394, section 2.2.2
+		var b [aes.BlockSize]byte
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i) //nolint:gosec // n is bounded by the wrapped length
+				binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+				copy(b[8:], out[i*8:i*8+8])
+				block.Decrypt(b[:], b[:])
+
+				copy(out[:8], b[:8])
+				copy(out[i*8:], b[8:])
+			}
+		}
+	}
+
+	// Check the initial value, the length and that the padding is zero
+	mli := int(binary.BigEndian.Uint32(out[4:8]))
+	ok := subtle.ConstantTimeCompare(out[:4], kwpIV[:])
+	if mli <= 8*(n-1) || mli > 8*n {
+		return nil, errKeyUnwrap
+	}
+	for _, c := range out[8+mli:] {
+		ok &= subtle.ConstantTimeByteEq(c, 0)
+	}
+	if ok != 1 {
+		return nil, errKeyUnwrap
+	}
+	return out[8 : 8+mli], nil
+}
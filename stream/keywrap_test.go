@@ -0,0 +1,167 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapKeyPadded(t *testing.T) {
+	unhex := func(s string) []byte {
+		t.Helper()
+
+		bs, err := hex.DecodeString(s)
+		require.NoError(t, err)
+		return bs
+	}
+
+	// Test vectors from RFC 5649, section 6
+	block, err := aes.NewCipher(unhex("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8"))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		key, wrapped string
+	}{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	} {
+		wrapped, err := wrapKeyPadded(block, unhex(tc.key))
+		require.NoError(t, err)
+		require.Equal(t, tc.wrapped, hex.EncodeToString(wrapped))
+
+		key, err := unwrapKeyPadded(block, wrapped)
+		require.NoError(t, err)
+		require.Equal(t, tc.key, hex.EncodeToString(key))
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		for size := 1; size <= 40; size++ {
+			key := bytes.Repeat([]byte{byte(size)}, size)
+			wrapped, err := wrapKeyPadded(block, key)
+			require.NoError(t, err)
+			require.Len(t, wrapped, 8+(size+7)/8*8)
+
+			got, err := unwrapKeyPadded(block, wrapped)
+			require.NoError(t, err)
+			require.Equal(t, key, got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := wrapKeyPadded(block, nil)
+		require.ErrorContains(t, err, "invalid key length 0")
+
+		wrapped, err := wrapKeyPadded(block, bytes.Repeat([]byte{1}, 32))
+		require.NoError(t, err)
+
+		for i := range wrapped {
+			modified := bytes.Clone(wrapped)
+			modified[i] ^= 0x01
+			_, err := unwrapKeyPadded(block, modified)
+			require.ErrorIs(t, err, errKeyUnwrap)
+		}
+		for _, bs := range [][]byte{nil, wrapped[:8], wrapped[:len(wrapped)-1], wrapped[:len(wrapped)-8]} {
+			_, err := unwrapKeyPadded(block, bs)
+			require.ErrorIs(t, err, errKeyUnwrap)
+		}
+
+		other, err := aes.NewCipher(bytes.Repeat([]byte{2}, 32))
+		require.NoError(t, err)
+		_, err = unwrapKeyPadded(other, wrapped)
+		require.ErrorIs(t, err, errKeyUnwrap)
+	})
+}
+
+func TestKeyWrapProvider(t *testing.T) {
+	kek := bytes.Repeat([]byte("k"), 32)
+	kp, err := NewKeyWrapProvider(kek)
+	require.NoError(t, err)
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)
+
+	write := func(t *testing.T, kp KeyProvider, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	read := func(kp KeyProvider, data []byte) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(data), kp)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		first := write(t, kp)
+		second := write(t, kp)
+
+		// Each stream has its own data key
+		h1, _, err := readHeader(bytes.NewReader(first))
+		require.NoError(t, err)
+		h2, _, err := readHeader(bytes.NewReader(second))
+		require.NoError(t, err)
+		require.Len(t, h1.WrappedKey, 8+keyWrapDataKeySize)
+		require.NotEqual(t, h1.WrappedKey, h2.WrappedKey)
+
+		for _, data := range [][]byte{first, second} {
+			got, err := read(kp, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+
+		// Providers with the same KEK read the stream
+		again, err := NewKeyWrapProvider(bytes.Clone(kek))
+		require.NoError(t, err)
+		got, err := read(again, first)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("wrong KEK", func(t *testing.T) {
+		other, err := NewKeyWrapProvider(bytes.Repeat([]byte("o"), 16))
+		require.NoError(t, err)
+
+		_, err = read(other, write(t, kp))
+		require.ErrorContains(t, err, "unwrapping data key")
+	})
+
+	t.Run("recipients and rewrap", func(t *testing.T) {
+		other, err := NewKeyWrapProvider(bytes.Repeat([]byte("o"), 24))
+		require.NoError(t, err)
+		require.Equal(t, "aes-kwp", providerID(other))
+
+		// Both stanzas have the same provider ID, so each one is tried
+		data := write(t, kp, WithRecipients(other))
+		for _, p := range []KeyProvider{kp, other} {
+			got, err := read(p, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+
+		var rewrapped bytes.Buffer
+		require.NoError(t, Rewrap(bytes.NewReader(write(t, kp)), &rewrapped, kp, other))
+		got, err := read(other, rewrapped.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("static key streams", func(t *testing.T) {
+		_, err := kp.UnwrapKey(nil)
+		require.ErrorContains(t, err, "requires a wrapped key")
+	})
+
+	t.Run("invalid KEK", func(t *testing.T) {
+		_, err := NewKeyWrapProvider([]byte("short"))
+		require.ErrorContains(t, err, "creating KEK cipher")
+	})
+}
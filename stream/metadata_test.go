@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	kp := NewStaticKeyProvider([]byte("1234567890123456"))
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100)
+	md := map[string]string{
+		"content-type": "text/plain",
+		"filename":     "fox.txt",
+		"tenant":       "acme",
+		"created":      "2026-10-17T12:00:00Z",
+	}
+
+	write := func(t *testing.T, data []byte, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	t.Run("Reader", func(t *testing.T) {
+		for _, compress := range []bool{false, true} {
+			opts := []Option{WithMetadata(md), WithChunkSize(100), WithConcurrency(4)}
+			if compress {
+				opts = append(opts, WithCompression())
+			}
+			data := write(t, original, opts...)
+
+			r, err := NewReader(bytes.NewReader(data), kp)
+			require.NoError(t, err)
+			require.Equal(t, md, r.Metadata())
+
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+	})
+
+	t.Run("ReaderAt", func(t *testing.T) {
+		data := write(t, original, WithMetadata(md), WithChunkSize(100))
+
+		r, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), kp)
+		require.NoError(t, err)
+		require.Equal(t, md, r.Metadata())
+
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("Inspect", func(t *testing.T) {
+		data := write(t, nil, WithMetadata(md))
+
+		info, err := Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, md, info.Metadata)
+
+		r, err := NewReader(bytes.NewReader(data), kp)
+		require.NoError(t, err)
+		require.Equal(t, md, r.Metadata())
+	})
+
+	t.Run("merged", func(t *testing.T) {
+		data := write(t, original, WithMetadata(map[string]string{"a": "1", "b": "2"}), WithMetadata(map[string]string{"b": "3"}))
+
+		r, err := NewReader(bytes.NewReader(data), kp)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"a": "1", "b": "3"}, r.Metadata())
+	})
+
+	t.Run("none", func(t *testing.T) {
+		data := write(t, original)
+
+		r, err := NewReader(bytes.NewReader(data), kp)
+		require.NoError(t, err)
+		require.Nil(t, r.Metadata())
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		data := write(t, original, WithMetadata(md))
+		i := bytes.Index(data, []byte("acme"))
+		require.Positive(t, i)
+
+		tampered := bytes.Clone(data)
+		copy(tampered[i:], "evil")
+
+		_, err := NewReader(bytes.NewReader(tampered), kp)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+
+		_, err = NewReaderAt(bytes.NewReader(tampered), int64(len(tampered)), kp)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+
+	t.Run("Rewrap", func(t *testing.T) {
+		oldKP := newWrappingKeyProvider(t, "old")
+		newKP := newWrappingKeyProvider(t, "new")
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, oldKP, WithMetadata(md))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		var rewrapped bytes.Buffer
+		require.NoError(t, Rewrap(&buf, &rewrapped, oldKP, newKP))
+
+		r, err := NewReader(&rewrapped, newKP)
+		require.NoError(t, err)
+		require.Equal(t, md, r.Metadata())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, kp, WithMetadata(map[string]string{"": "value"}))
+		require.ErrorContains(t, err, "empty metadata key")
+
+		_, err = NewWriter(io.Discard, kp, WithMetadata(map[string]string{strings.Repeat("k", 256): "value"}))
+		require.ErrorContains(t, err, "longer than 255 bytes")
+
+		_, err = NewWriter(io.Discard, kp, WithMetadata(map[string]string{"k": strings.Repeat("v", 1<<16)}))
+		require.ErrorContains(t, err, `metadata value of "k" too large`)
+
+		large := make(map[string]string)
+		for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p", "q"} {
+			large[k] = strings.Repeat("v", 1<<16-3)
+		}
+		_, err = NewWriter(io.Discard, kp, WithMetadata(large))
+		require.ErrorContains(t, err, "header fields too large")
+	})
+}
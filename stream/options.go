@@ -1,17 +1,33 @@
 package stream
 
+import "maps"
+
 type options struct {
-	compress  bool
-	chunkSize int
+	codec       Codec
+	level       int
+	chunkSize   int
+	concurrency int
+	recipients  []KeyProvider
+	metadata    map[string]string
+	cipher      Cipher
+	legacyV1    bool
 }
 
-// Option configures streaming encryption behavior.
+// Option configures streaming encryption behavior. Options which only apply to
+// writing are ignored by NewReader.
 type Option func(*options)
 
-// WithCompression enables gzip compression before encryption.
+// WithCompression enables gzip compression at the default level before encryption.
 func WithCompression() Option {
+	return WithCompressionCodec(CodecGzip, 0)
+}
+
+// WithCompressionCodec compresses data with codec at level before encryption. A level
+// of zero uses the codec's default. NewReader reads the codec from the header.
+func WithCompressionCodec(codec Codec, level int) Option {
 	return func(o *options) {
-		o.compress = true
+		o.codec = codec
+		o.level = level
 	}
 }
 
@@ -21,3 +37,53 @@ func WithChunkSize(size int) Option {
 		o.chunkSize = size
 	}
 }
+
+// WithCipher sets the cipher suite which encrypts chunks (default AESGCM). NewReader
+// reads the suite from the header. ChaCha20Poly1305 and XChaCha20Poly1305 require
+// 256-bit data keys.
+func WithCipher(c Cipher) Option {
+	return func(o *options) {
+		o.cipher = c
+	}
+}
+
+// WithConcurrency encrypts or decrypts up to n chunks in parallel. Chunks are still
+// written and read in order and the format is unchanged. Up to n chunks are buffered
+// in memory, and values below 2 disable concurrency.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithRecipients adds a key stanza for each provider to the header, so the stream
+// can be read with any of them as well as the KeyProvider passed to NewWriter. The
+// data key is wrapped for each recipient with its KeyWrapper.
+func WithRecipients(kps ...KeyProvider) Option {
+	return func(o *options) {
+		o.recipients = append(o.recipients, kps...)
+	}
+}
+
+// WithMetadata stores key/value pairs in the header, where they're authenticated with
+// the rest of the header. Keys must be 1 to 255 bytes. Metadata isn't encrypted, so it
+// mustn't hold secrets. Calling WithMetadata again adds to the earlier entries.
+func WithMetadata(md map[string]string) Option {
+	return func(o *options) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]string, len(md))
+		}
+		maps.Copy(o.metadata, md)
+	}
+}
+
+// WithLegacyV1 lets NewReader, NewReaderAt and Verify read format version 1 streams,
+// which are rejected with ErrLegacyVersion by default. Version 1 has no final chunk,
+// so a truncated stream isn't detected, and any newer stream can be downgraded by
+// rewriting its header as version 1 and dropping its chunks, which reads as empty.
+// Only enable it while reading data written by releases before version 3.
+func WithLegacyV1() Option {
+	return func(o *options) {
+		o.legacyV1 = true
+	}
+}
@@ -1,135 +1,328 @@
 package stream
 
 import (
-	"compress/gzip"
-	"crypto/aes"
+	"bytes"
+	"context"
 	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"maps"
+	"slices"
+	"sync"
 )
 
 // chunkReader reads and decrypts chunks from the underlying reader.
 type chunkReader struct {
 	src         io.Reader
-	gcm         cipher.AEAD
+	aead        cipher.AEAD
 	noncePrefix [noncePrefixSize]byte
 	headerAAD   []byte // for verifying first chunk
 	buf         []byte // unconsumed plaintext from current chunk
 	counter     uint64
+	version     byte
 	done        bool
+	read        uint64   // bytes of chunks read, including their length
+	trailer     *trailer // authenticated trailer of version 3 streams
+	digest      []byte   // from the final chunk of version 3 streams
+
+	// Up to concurrency chunks are read ahead and decrypted in parallel. Their
+	// plaintext is queued, and an error after them is returned once it's read.
+	concurrency int
+	queue       [][]byte
+	err         error
+}
+
+// sealedChunk is a chunk which has been read but not decrypted.
+type sealedChunk struct {
+	counter uint64
+	data    []byte // nonce + ciphertext
+	aad     []byte
+	trailer []byte // of the final chunk from version 3
 }
 
 func (cr *chunkReader) Read(p []byte) (int, error) {
-	if len(cr.buf) > 0 {
-		n := copy(p, cr.buf)
-		cr.buf = cr.buf[n:]
-		return n, nil
+	for len(cr.buf) == 0 {
+		if len(cr.queue) > 0 {
+			cr.buf, cr.queue = cr.queue[0], cr.queue[1:]
+			continue
+		}
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.readNextChunk(); err != nil {
+			return 0, err
+		}
 	}
 
-	if cr.done {
-		return 0, io.EOF
-	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
 
-	if err := cr.readNextChunk(); err != nil {
-		return 0, err
+// readNextChunk reads and decrypts the next chunks, up to concurrency of them,
+// and queues their plaintext.
+func (cr *chunkReader) readNextChunk() error {
+	n := max(cr.concurrency, 1)
+
+	var batch []*sealedChunk
+	var readErr error
+	for len(batch) < n && !cr.done {
+		c, err := cr.readChunk()
+		if err != nil {
+			readErr = err
+			break
+		}
+		if c != nil {
+			batch = append(batch, c)
+		}
 	}
 
-	if cr.done {
-		return 0, io.EOF
+	plaintexts, errs := cr.openChunks(batch)
+	for i, c := range batch {
+		if errs[i] != nil {
+			return cr.fail(errs[i])
+		}
+		if c.trailer != nil {
+			if err := cr.checkTrailer(c.trailer, plaintexts[i]); err != nil {
+				return cr.fail(err)
+			}
+			continue
+		}
+		cr.queue = append(cr.queue, plaintexts[i])
+	}
+	if readErr != nil {
+		return cr.fail(readErr)
 	}
+	return nil
+}
 
-	n := copy(p, cr.buf)
-	cr.buf = cr.buf[n:]
-	return n, nil
+// fail records err to be returned once the queued plaintext has been read.
+func (cr *chunkReader) fail(err error) error {
+	cr.err = err
+	if len(cr.queue) > 0 {
+		return nil
+	}
+	return err
 }
 
-func (cr *chunkReader) readNextChunk() error {
+// readChunk reads the next chunk and checks its nonce. It returns nil at the end marker.
+func (cr *chunkReader) readChunk() (*sealedChunk, error) {
 	// Read 4-byte chunk length
 	var lenBuf [4]byte
 	if _, err := io.ReadFull(cr.src, lenBuf[:]); err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-			return fmt.Errorf("unexpected end of stream reading chunk length: %w", err)
+			return nil, fmt.Errorf("unexpected end of stream reading chunk length: %w", err)
 		}
-		return fmt.Errorf("reading chunk length: %w", err)
+		return nil, fmt.Errorf("reading chunk length: %w", err)
 	}
 
 	chunkLen := binary.BigEndian.Uint32(lenBuf[:])
 
 	// End marker
 	if chunkLen == 0 {
+		// From version 3 the end marker follows the final chunk, which ends reading
+		if cr.version != formatVersion1 {
+			return nil, fmt.Errorf("end marker after chunk %d: %w", cr.counter, ErrTruncated)
+		}
 		cr.done = true
-		return nil
+		return nil, nil
 	}
 
-	if cr.counter >= 1<<40 {
-		return errors.New("maximum chunk count exceeded to prevent nonce reuse")
+	if cr.counter >= maxChunks {
+		return nil, errors.New("maximum chunk count exceeded to prevent nonce reuse")
 	}
 
 	// Read the chunk (nonce + ciphertext + tag)
 	chunk := make([]byte, chunkLen)
 	if _, err := io.ReadFull(cr.src, chunk); err != nil {
-		return fmt.Errorf("reading chunk data: %w", err)
+		return nil, fmt.Errorf("reading chunk data: %w", err)
 	}
+	cr.read += uint64(len(lenBuf)) + uint64(chunkLen)
 
 	if len(chunk) < nonceSize {
-		return errors.New("chunk too small to contain nonce")
+		return nil, errors.New("chunk too small to contain nonce")
 	}
 
 	// Verify nonce counter
 	expectedNonce := buildNonce(cr.noncePrefix, cr.counter)
 	var actualNonce [nonceSize]byte
 	copy(actualNonce[:], chunk[:nonceSize])
+	if cr.counter == 0 {
+		actualNonce = clearRewrapEpoch(actualNonce)
+	}
+	final := false
+	if cr.version != formatVersion1 {
+		final = actualNonce[noncePrefixSize]&finalChunkFlag != 0
+		actualNonce[noncePrefixSize] &^= finalChunkFlag
+	}
 	if actualNonce != expectedNonce {
-		return fmt.Errorf("nonce counter mismatch at chunk %d", cr.counter)
+		return nil, fmt.Errorf("nonce counter mismatch at chunk %d", cr.counter)
 	}
 
 	// For chunk 0, pass the serialized header as AAD (Additional Authenticated Data).
 	// GCM.Open will recompute the auth tag using this AAD and fail if it doesn't match
 	// what was used during Seal — this cryptographically binds the header to the data,
 	// so any tampering with flags, nonce prefix, or wrapped key causes decryption to fail.
-	var aad []byte
+	c := &sealedChunk{
+		counter: cr.counter,
+		data:    chunk,
+	}
 	if cr.counter == 0 {
-		aad = cr.headerAAD
+		c.aad = cr.headerAAD
 	}
 
-	plaintext, err := cr.gcm.Open(nil, chunk[:nonceSize], chunk[nonceSize:], aad)
-	if err != nil {
-		return fmt.Errorf("decrypting chunk %d: %w", cr.counter, err)
+	// The final chunk is followed by the end marker and trailer, which is
+	// authenticated with the final chunk.
+	if final {
+		tb := make([]byte, 4+trailerSize)
+		if _, err := io.ReadFull(cr.src, tb); err != nil {
+			return nil, fmt.Errorf("reading trailer: %w", err)
+		}
+		if binary.BigEndian.Uint32(tb[:4]) != 0 {
+			return nil, errors.New("missing end marker after final chunk")
+		}
+		c.trailer = tb[4:]
+		c.aad = append(c.aad[:len(c.aad):len(c.aad)], c.trailer...)
+		cr.done = true
 	}
 
-	cr.buf = plaintext
 	cr.counter++
+	return c, nil
+}
+
+// openChunks decrypts chunks, in parallel when there's more than one.
+func (cr *chunkReader) openChunks(chunks []*sealedChunk) ([][]byte, []error) {
+	plaintexts := make([][]byte, len(chunks))
+	errs := make([]error, len(chunks))
+	if len(chunks) == 1 {
+		plaintexts[0], errs[0] = cr.open(chunks[0])
+		return plaintexts, errs
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plaintexts[i], errs[i] = cr.open(c)
+		}()
+	}
+	wg.Wait()
+	return plaintexts, errs
+}
+
+func (cr *chunkReader) open(c *sealedChunk) ([]byte, error) {
+	plaintext, err := cr.aead.Open(nil, c.data[:nonceSize], c.data[nonceSize:], c.aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting chunk %d: %w", c.counter, err)
+	}
+	return plaintext, nil
+}
+
+// checkTrailer verifies the authenticated trailer against the chunks read, and
+// keeps the digest from the plaintext of the final chunk.
+func (cr *chunkReader) checkTrailer(tb []byte, plaintext []byte) error {
+	t := parseTrailer(tb)
+	if t.CiphertextSize != cr.read {
+		return fmt.Errorf("read %d bytes of chunks but trailer records %d", cr.read, t.CiphertextSize)
+	}
+	if len(plaintext) != digestSize {
+		return errors.New("final chunk does not hold a digest")
+	}
+	cr.digest = plaintext
+	cr.trailer = &t
 	return nil
 }
 
 // Reader is the public streaming decryption reader.
 type Reader struct {
-	chunks     *chunkReader
-	gzipReader *gzip.Reader // nil if no compression
-	closer     io.Closer    // underlying source to close
-	closed     bool
-	err        error // sticky error from first read failure
+	chunks       *chunkReader
+	decompressor io.ReadCloser // nil if no compression
+	read         uint64        // plaintext bytes returned from Read
+	hash         hash.Hash     // SHA-256 of the plaintext returned from Read
+	digest       []byte        // sum of hash once all plaintext has been read
+	closer       io.Closer     // underlying source to close
+	metadata     map[string]string
+	closed       bool
+	err          error // sticky error from first read failure
 }
 
 // NewReader returns a streaming decryption reader. It reads the CRFS header,
 // unwraps the data key, and returns a reader that decrypts and decompresses on Read.
 // The caller must call Close on the returned Reader.
-func NewReader(src io.Reader, kp KeyProvider) (*Reader, error) {
+func NewReader(src io.Reader, kp KeyProvider, opts ...Option) (*Reader, error) {
+	return NewReaderContext(context.Background(), src, kp, opts...)
+}
+
+// NewReaderContext is like NewReader but passes ctx to the KeyProvider when
+// unwrapping the data key.
+func NewReaderContext(ctx context.Context, src io.Reader, kp KeyProvider, opts ...Option) (*Reader, error) {
+	o := options{}
+	for _, fn := range opts {
+		fn(&o)
+	}
+
 	h, aad, err := readHeader(src)
 	if err != nil {
 		return nil, fmt.Errorf("reading header: %w", err)
 	}
+	if h.Version == formatVersion1 && !o.legacyV1 {
+		return nil, ErrLegacyVersion
+	}
+
+	key, err := headerKey(ctx, kp, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newReader(src, key, h, aad, o.concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.Metadata) > 0 {
+		// Metadata is only trusted once chunk 0 has authenticated the header. Its
+		// plaintext is queued for Read.
+		if r.chunks.counter == 0 {
+			if err := r.chunks.readNextChunk(); err != nil {
+				return nil, fmt.Errorf("authenticating header: %w", err)
+			}
+		}
+		r.metadata = h.Metadata
+	}
+	return r, nil
+}
+
+// headerKey returns the data key for a stream, unwrapping it from the header
+// when the stream has a wrapped key.
+func headerKey(ctx context.Context, kp KeyProvider, h *fileHeader) ([]byte, error) {
+	if len(h.Stanzas) > 0 {
+		key, _, err := stanzaKey(ctx, kp, h.Stanzas)
+		return key, err
+	}
 
 	var key []byte
 	if len(h.WrappedKey) > 0 {
-		key, err = kp.UnwrapKey(h.WrappedKey)
+		var err error
+		key, err = unwrapKey(ctx, kp, h.WrappedKey)
 		if err != nil {
 			return nil, fmt.Errorf("unwrapping data key: %w", err)
 		}
+	} else if skh, ok := kp.(staticKeyHolder); ok {
+		var err error
+		key, err = skh.staticKey(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting key: %w", err)
+		}
 	} else {
-		dk, err := kp.GenerateKey()
+		dk, err := generateKey(ctx, kp)
 		if err != nil {
 			return nil, fmt.Errorf("getting key: %w", err)
 		}
@@ -139,53 +332,79 @@ func NewReader(src io.Reader, kp KeyProvider) (*Reader, error) {
 	if key == nil {
 		return nil, fmt.Errorf("no key provided")
 	}
-
-	compress := h.Flags&flagGzip != 0
-	return newReader(src, key, aad, compress)
+	return key, nil
 }
 
-func newReader(src io.Reader, key []byte, headerAAD []byte, compress bool) (*Reader, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("creating AES cipher: %w", err)
+// stanzaKey unwraps the data key from the first stanza kp can open and returns its
+// index. Only stanzas with the provider's ID are tried when it has one.
+func stanzaKey(ctx context.Context, kp KeyProvider, stanzas []Stanza) ([]byte, int, error) {
+	id := providerID(kp)
+
+	var errs []error
+	for i, st := range stanzas {
+		if id != "" && st.ProviderID != id {
+			continue
+		}
+		key, err := unwrapKey(ctx, kp, st.WrappedKey)
+		if err == nil && key != nil {
+			return key, i, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, 0, fmt.Errorf("unwrapping data key: %w", ctxErr)
+		}
+		if err == nil {
+			err = errors.New("no key provided")
+		}
+		errs = append(errs, fmt.Errorf("key stanza %d: %w", i, err))
+	}
+	if len(errs) == 0 {
+		return nil, 0, fmt.Errorf("no key stanza for provider %q", id)
 	}
+	return nil, 0, fmt.Errorf("unwrapping data key: %w", errors.Join(errs...))
+}
 
-	gcm, err := cipher.NewGCM(block)
+func newReader(src io.Reader, key []byte, h *fileHeader, headerAAD []byte, concurrency int) (*Reader, error) {
+	aead, err := newAEAD(key, h)
 	if err != nil {
-		return nil, fmt.Errorf("creating GCM: %w", err)
+		return nil, err
 	}
 
-	var prefix [noncePrefixSize]byte
-	copy(prefix[:], headerAAD[6:13])
-
 	cr := &chunkReader{
 		src:         src,
-		gcm:         gcm,
-		noncePrefix: prefix,
+		aead:        aead,
+		noncePrefix: h.NoncePrefix,
 		headerAAD:   headerAAD,
+		version:     h.Version,
+		concurrency: concurrency,
 	}
 
-	r := &Reader{chunks: cr}
+	r := &Reader{chunks: cr, hash: sha256.New()}
 
 	if closer, ok := src.(io.Closer); ok {
 		r.closer = closer
 	}
 
-	if compress {
-		// Need to read at least one chunk to initialize gzip reader
+	if codec := h.codec(); codec != CodecNone {
+		// Read the first chunk so the decompressor's header errors are reported here
 		if err := cr.readNextChunk(); err != nil {
-			return nil, fmt.Errorf("reading first chunk for gzip: %w", err)
+			return nil, fmt.Errorf("reading first chunk for %v: %w", codec, err)
 		}
-		gz, err := gzip.NewReader(cr)
+		r.decompressor, err = newDecompressor(codec, cr)
 		if err != nil {
-			return nil, fmt.Errorf("creating gzip reader: %w", err)
+			return nil, fmt.Errorf("creating %v reader: %w", codec, err)
 		}
-		r.gzipReader = gz
 	}
 
 	return r, nil
 }
 
+// Metadata returns the metadata stored in the header with WithMetadata, or nil when
+// there is none. It has been authenticated by NewReader, so it's available before any
+// data is read.
+func (r *Reader) Metadata() map[string]string {
+	return maps.Clone(r.metadata)
+}
+
 func (r *Reader) Read(p []byte) (int, error) {
 	if r.closed {
 		return 0, ErrClosed
@@ -195,17 +414,54 @@ func (r *Reader) Read(p []byte) (int, error) {
 	}
 	var n int
 	var err error
-	if r.gzipReader != nil {
-		n, err = r.gzipReader.Read(p)
+	if r.decompressor != nil {
+		n, err = r.decompressor.Read(p)
 	} else {
 		n, err = r.chunks.Read(p)
 	}
+	r.hash.Write(p[:n])
+	r.read += uint64(n) //nolint:gosec // n is never negative
+	if err == io.EOF {
+		err = r.finish()
+	}
 	if err != nil && err != io.EOF {
 		r.err = err
 	}
 	return n, err
 }
 
+// finish checks the end of the stream once all plaintext has been read. It
+// returns io.EOF when the stream is complete.
+func (r *Reader) finish() error {
+	// The compressed stream may end before the final chunk has been read
+	for !r.chunks.done {
+		var b [1]byte
+		n, err := r.chunks.Read(b[:])
+		if n > 0 {
+			return errors.New("unexpected data after compressed stream")
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if t := r.chunks.trailer; t != nil && t.PlaintextSize != r.read {
+		return fmt.Errorf("read %d bytes of plaintext but trailer records %d", r.read, t.PlaintextSize)
+	}
+	digest := r.hash.Sum(nil)
+	if r.chunks.digest != nil && !bytes.Equal(digest, r.chunks.digest) {
+		return ErrDigestMismatch
+	}
+	r.digest = digest
+	return io.EOF
+}
+
+// Digest returns the SHA-256 of the plaintext once Read has returned io.EOF, or nil
+// before. For version 3 streams it has been checked to match the digest stored by
+// the Writer.
+func (r *Reader) Digest() []byte {
+	return slices.Clone(r.digest)
+}
+
 // Close closes the reader and the underlying source (if it implements io.Closer).
 func (r *Reader) Close() error {
 	if r.closed {
@@ -213,8 +469,8 @@ func (r *Reader) Close() error {
 	}
 	r.closed = true
 	var errs []error
-	if r.gzipReader != nil {
-		if err := r.gzipReader.Close(); err != nil {
+	if r.decompressor != nil {
+		if err := r.decompressor.Close(); err != nil {
 			errs = append(errs, err)
 		}
 	}
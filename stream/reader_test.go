@@ -20,16 +20,16 @@ func TestReaderCorruptionDetection(t *testing.T) {
 		data := buf.Bytes()
 
 		// Find the first chunk data (after header + 4-byte length)
-		chunkStart := fixedHeaderSize + 4 + nonceSize
+		chunkStart := headerSize(t, data) + 4 + nonceSize
 		if chunkStart < len(data) {
 			data[chunkStart] ^= 0xFF // flip bits
 		}
 
 		r := bytes.NewReader(data)
-		_, aad, err := readHeader(r)
+		h, aad, err := readHeader(r)
 		require.NoError(t, err)
 
-		dr, err := newReader(r, key, aad, false)
+		dr, err := newReader(r, key, h, aad, 0)
 		require.NoError(t, err)
 
 		_, err = io.ReadAll(dr)
@@ -41,14 +41,14 @@ func TestReaderCorruptionDetection(t *testing.T) {
 		buf := writeTestData(t, key, original, false, 0)
 		data := buf.Bytes()
 
-		// Tamper with flags byte in the header
-		data[5] ^= 0xFF
+		// Tamper with an unused bit of the flags byte in the header
+		data[5] ^= 0x80
 
 		r := bytes.NewReader(data)
-		_, aad, err := readHeader(r)
+		h, aad, err := readHeader(r)
 		require.NoError(t, err)
 
-		dr, err := newReader(r, key, aad, false)
+		dr, err := newReader(r, key, h, aad, 0)
 		require.NoError(t, err)
 
 		_, err = io.ReadAll(dr)
@@ -61,10 +61,10 @@ func TestReaderCorruptionDetection(t *testing.T) {
 
 		wrongKey := []byte("6543210987654321")
 		r := bytes.NewReader(buf.Bytes())
-		_, aad, err := readHeader(r)
+		h, aad, err := readHeader(r)
 		require.NoError(t, err)
 
-		dr, err := newReader(r, wrongKey, aad, false)
+		dr, err := newReader(r, wrongKey, h, aad, 0)
 		require.NoError(t, err)
 
 		_, err = io.ReadAll(dr)
@@ -75,54 +75,20 @@ func TestReaderCorruptionDetection(t *testing.T) {
 		buf := writeTestData(t, key, original, false, 10) // small chunks
 		data := buf.Bytes()
 
-		// Parse the data to find chunk boundaries and swap two chunks
-		offset := fixedHeaderSize
-		type chunkData struct {
-			length uint32
-			data   []byte
-		}
-		var chunks []chunkData
-		for {
-			if offset+4 > len(data) {
-				break
-			}
-			length := binary.BigEndian.Uint32(data[offset : offset+4])
-			if length == 0 {
-				break // end marker
-			}
-			cd := chunkData{
-				length: length,
-				data:   make([]byte, length),
-			}
-			copy(cd.data, data[offset+4:offset+4+int(length)])
-			chunks = append(chunks, cd)
-			offset += 4 + int(length)
-		}
-
+		header, chunks, tail := splitChunks(t, data)
 		if len(chunks) < 2 {
 			t.Skip("need at least 2 chunks to test reordering")
 		}
 
 		// Swap first two chunks
 		chunks[0], chunks[1] = chunks[1], chunks[0]
+		reordered := joinChunks(header, chunks, tail)
 
-		// Reconstruct
-		var reordered bytes.Buffer
-		reordered.Write(data[:fixedHeaderSize]) // header
-		for _, c := range chunks {
-			var lenBuf [4]byte
-			binary.BigEndian.PutUint32(lenBuf[:], c.length)
-			reordered.Write(lenBuf[:])
-			reordered.Write(c.data)
-		}
-		var endMarker [4]byte
-		reordered.Write(endMarker[:])
-
-		r := bytes.NewReader(reordered.Bytes())
-		_, aad, err := readHeader(r)
+		r := bytes.NewReader(reordered)
+		h, aad, err := readHeader(r)
 		require.NoError(t, err)
 
-		dr, err := newReader(r, key, aad, false)
+		dr, err := newReader(r, key, h, aad, 0)
 		require.NoError(t, err)
 
 		_, err = io.ReadAll(dr)
@@ -138,10 +104,10 @@ func TestReaderCorruptionDetection(t *testing.T) {
 		truncated := data[:len(data)-4]
 
 		r := bytes.NewReader(truncated)
-		_, aad, err := readHeader(r)
+		h, aad, err := readHeader(r)
 		require.NoError(t, err)
 
-		dr, err := newReader(r, key, aad, false)
+		dr, err := newReader(r, key, h, aad, 0)
 		require.NoError(t, err)
 
 		_, err = io.ReadAll(dr)
@@ -149,6 +115,217 @@ func TestReaderCorruptionDetection(t *testing.T) {
 	})
 }
 
+func TestReaderTruncation(t *testing.T) {
+	key := []byte("1234567890123456")
+	original := []byte("The quick brown fox jumps over the lazy dog")
+
+	readAll := func(t *testing.T, data []byte) ([]byte, error) {
+		t.Helper()
+
+		r := bytes.NewReader(data)
+		h, aad, err := readHeader(r)
+		require.NoError(t, err)
+
+		dr, err := newReader(r, key, h, aad, 0)
+		require.NoError(t, err)
+		return io.ReadAll(dr)
+	}
+
+	t.Run("dropped chunks", func(t *testing.T) {
+		buf := writeVersionedTestData(t, formatVersion, key, original, false, 10)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+		require.Len(t, chunks, 6) // 5 data chunks and the final chunk
+
+		for keep := 0; keep < len(chunks); keep++ {
+			_, err := readAll(t, joinChunks(header, chunks[:keep], tail))
+			require.ErrorIs(t, err, ErrTruncated, "keeping %d chunks", keep)
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		buf := writeVersionedTestData(t, formatVersion, key, nil, false, 0)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+		require.Len(t, chunks, 1) // empty final chunk
+
+		_, err := readAll(t, joinChunks(header, nil, tail))
+		require.ErrorIs(t, err, ErrTruncated)
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		data := bytes.Repeat(original, 1000)
+		buf := writeVersionedTestData(t, formatVersion, key, data, true, 64)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+		require.Greater(t, len(chunks), 2)
+
+		_, err := readAll(t, joinChunks(header, chunks[:len(chunks)-1], tail))
+		require.ErrorIs(t, err, ErrTruncated)
+	})
+
+	t.Run("final flag moved", func(t *testing.T) {
+		buf := writeVersionedTestData(t, formatVersion, key, original, false, 10)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+
+		// Mark an earlier chunk as final and drop the rest
+		chunks[1][noncePrefixSize] |= finalChunkFlag
+		_, err := readAll(t, joinChunks(header, chunks[:2], tail))
+		require.ErrorContains(t, err, "decrypting chunk 1")
+	})
+
+	t.Run("chunk after final", func(t *testing.T) {
+		buf := writeVersionedTestData(t, formatVersion, key, original, false, 10)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+		chunks = append(chunks, chunks[len(chunks)-1])
+
+		_, err := readAll(t, joinChunks(header, chunks, tail))
+		require.ErrorContains(t, err, "missing end marker after final chunk")
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		buf := writeVersionedTestData(t, formatVersion1, key, original, false, 10)
+		require.True(t, HasHeader(buf.Bytes()))
+
+		got := readTestData(t, key, buf.Bytes())
+		require.Equal(t, original, got)
+
+		// Version 1 streams have no final chunk, so truncation at a chunk
+		// boundary can't be detected.
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+		require.Len(t, chunks, 5)
+		got = readTestData(t, key, joinChunks(header, chunks[:2], tail))
+		require.Equal(t, original[:20], got)
+	})
+
+	t.Run("version 1 from NewReader", func(t *testing.T) {
+		kp := NewStaticKeyProvider(key)
+		buf := writeVersionedTestData(t, formatVersion1, key, original, true, 0)
+
+		_, err := NewReader(bytes.NewReader(buf.Bytes()), kp)
+		require.ErrorIs(t, err, ErrLegacyVersion)
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), kp, WithLegacyV1())
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("downgrade to version 1", func(t *testing.T) {
+		kp := NewStaticKeyProvider(key)
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		// Rewriting the header as version 1 with no chunks would read as empty
+		h, _, err := readHeader(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		downgraded := &fileHeader{Version: formatVersion1, NoncePrefix: h.NoncePrefix, WrappedKey: h.WrappedKey}
+		var tampered bytes.Buffer
+		require.NoError(t, writeHeader(&tampered, downgraded))
+		tampered.Write([]byte{0, 0, 0, 0})
+
+		_, err = NewReader(bytes.NewReader(tampered.Bytes()), kp)
+		require.ErrorIs(t, err, ErrLegacyVersion)
+
+		_, err = NewReaderAt(bytes.NewReader(tampered.Bytes()), int64(tampered.Len()), kp)
+		require.ErrorIs(t, err, ErrLegacyVersion)
+
+		_, _, err = Verify(bytes.NewReader(tampered.Bytes()), kp)
+		require.ErrorIs(t, err, ErrLegacyVersion)
+	})
+}
+
+func TestReaderTrailer(t *testing.T) {
+	key := []byte("1234567890123456")
+	original := []byte("The quick brown fox jumps over the lazy dog")
+
+	readAll := func(t *testing.T, data []byte) ([]byte, error) {
+		t.Helper()
+
+		r := bytes.NewReader(data)
+		h, aad, err := readHeader(r)
+		require.NoError(t, err)
+
+		dr, err := newReader(r, key, h, aad, 0)
+		require.NoError(t, err)
+		return io.ReadAll(dr)
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			buf := writeTestData(t, key, original, compress, 10)
+			header, chunks, tail := splitChunks(t, buf.Bytes())
+			require.Len(t, tail, trailerSize)
+
+			tr := parseTrailer(tail)
+			require.Equal(t, uint64(len(original)), tr.PlaintextSize)
+			require.Equal(t, uint64(buf.Len()-len(header)-4-trailerSize), tr.CiphertextSize)
+
+			got, err := readAll(t, buf.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+
+			// Changing either size fails authentication of the final chunk
+			for i := range tail {
+				modified := bytes.Clone(tail)
+				modified[i] ^= 0x01
+				_, err := readAll(t, joinChunks(header, chunks, modified))
+				require.ErrorContains(t, err, fmt.Sprintf("decrypting chunk %d", len(chunks)-1))
+			}
+
+			_, err = readAll(t, joinChunks(header, chunks, tail[:8]))
+			require.ErrorContains(t, err, "reading trailer")
+		})
+	}
+}
+
+// splitChunks returns the header, each chunk (nonce + ciphertext) and the bytes
+// after the end marker of a stream.
+func splitChunks(t *testing.T, data []byte) ([]byte, [][]byte, []byte) {
+	t.Helper()
+
+	offset := headerSize(t, data)
+	var chunks [][]byte
+	for {
+		require.LessOrEqual(t, offset+4, len(data))
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if length == 0 {
+			break // end marker
+		}
+		chunks = append(chunks, bytes.Clone(data[offset:offset+length]))
+		offset += length
+	}
+	return data[:headerSize(t, data)], chunks, data[offset:]
+}
+
+// joinChunks builds a stream from the header and chunks, followed by the end marker
+// and tail.
+func joinChunks(header []byte, chunks [][]byte, tail []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(header)
+	for _, c := range chunks {
+		var lenBuf [4]byte
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(c))) //nolint:gosec // test chunks are small
+		buf.Write(lenBuf[:])
+		buf.Write(c)
+	}
+	var endMarker [4]byte
+	buf.Write(endMarker[:])
+	buf.Write(tail)
+	return buf.Bytes()
+}
+
+func headerSize(t *testing.T, data []byte) int {
+	t.Helper()
+
+	_, aad, err := readHeader(bytes.NewReader(data))
+	require.NoError(t, err)
+	return len(aad)
+}
+
 func TestReaderUseAfterClose(t *testing.T) {
 	key := []byte("1234567890123456")
 	kp := NewStaticKeyProvider(key)
@@ -193,14 +370,14 @@ func TestReaderUseAfterClose(t *testing.T) {
 		data := buf.Bytes()
 
 		// Tamper with ciphertext to cause a decryption failure
-		chunkStart := fixedHeaderSize + 4 + nonceSize
+		chunkStart := headerSize(t, data) + 4 + nonceSize
 		data[chunkStart] ^= 0xFF
 
 		r := bytes.NewReader(data)
-		_, aad, err := readHeader(r)
+		h, aad, err := readHeader(r)
 		require.NoError(t, err)
 
-		dr, err := newReader(r, key, aad, false)
+		dr, err := newReader(r, key, h, aad, 0)
 		require.NoError(t, err)
 
 		// First read fails with decryption error
@@ -221,10 +398,10 @@ func TestReaderSmallReads(t *testing.T) {
 	buf := writeTestData(t, key, original, false, 10)
 
 	r := bytes.NewReader(buf.Bytes())
-	_, aad, err := readHeader(r)
+	h, aad, err := readHeader(r)
 	require.NoError(t, err)
 
-	dr, err := newReader(r, key, aad, false)
+	dr, err := newReader(r, key, h, aad, 0)
 	require.NoError(t, err)
 
 	// Read one byte at a time
@@ -0,0 +1,369 @@
+package stream
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"sync"
+)
+
+// ReaderAt decrypts byte ranges of an uncompressed CRFS stream. Only the chunks
+// covering a read are fetched and decrypted.
+//
+// ReaderAt implements io.Reader, io.ReaderAt and io.Seeker. ReadAt may be called
+// concurrently, Read and Seek share an offset and may not.
+type ReaderAt struct {
+	src         io.ReaderAt
+	aead        cipher.AEAD
+	noncePrefix [noncePrefixSize]byte
+	headerAAD   []byte
+	version     byte
+
+	dataOffset int64  // offset of the first chunk's length
+	stride     int64  // length of a full chunk, including its 4-byte length
+	lastLen    int64  // length of the last data chunk, including its 4-byte length
+	chunks     int64  // data chunks, not counting the final chunk of version 3
+	trailer    []byte // version 3 trailer
+	finalLen   int64  // length of the version 3 final chunk, including its 4-byte length
+	chunkSize  int64  // plaintext bytes in a full chunk
+	size       int64  // plaintext size
+	metadata   map[string]string
+
+	offset int64 // for Read and Seek
+
+	mu          sync.Mutex
+	cachedIndex int64
+	cached      []byte
+}
+
+// NewReaderAt returns a ReaderAt for the CRFS stream of the given size in src. The
+// header is read and authenticated, the data key unwrapped and the chunk layout
+// checked before returning.
+//
+// Compressed streams can't be read at random offsets and return an error.
+// WithLegacyV1 is the only option used.
+func NewReaderAt(src io.ReaderAt, size int64, kp KeyProvider, opts ...Option) (*ReaderAt, error) {
+	return NewReaderAtContext(context.Background(), src, size, kp, opts...)
+}
+
+// NewReaderAtContext is like NewReaderAt but passes ctx to the KeyProvider when
+// unwrapping the data key.
+func NewReaderAtContext(ctx context.Context, src io.ReaderAt, size int64, kp KeyProvider, opts ...Option) (*ReaderAt, error) {
+	o := options{}
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	h, aad, err := readHeader(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if h.Version == formatVersion1 && !o.legacyV1 {
+		return nil, ErrLegacyVersion
+	}
+	if h.codec() != CodecNone {
+		return nil, errors.New("random access requires an uncompressed stream")
+	}
+
+	key, err := headerKey(ctx, kp, h)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReaderAt(src, size, key, h, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.Metadata) > 0 {
+		// Authenticate the header with chunk 0 before trusting its metadata
+		if _, err := r.chunk(0); err != nil {
+			return nil, fmt.Errorf("authenticating header: %w", err)
+		}
+		r.metadata = h.Metadata
+	}
+	return r, nil
+}
+
+func newReaderAt(src io.ReaderAt, size int64, key []byte, h *fileHeader, headerAAD []byte) (*ReaderAt, error) {
+	aead, err := newAEAD(key, h)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ReaderAt{
+		src:         src,
+		aead:        aead,
+		headerAAD:   headerAAD,
+		version:     headerAAD[4],
+		dataOffset:  int64(len(headerAAD)),
+		cachedIndex: -1,
+	}
+	copy(r.noncePrefix[:], headerAAD[6:13])
+
+	if r.version == formatVersion3 {
+		err = r.readTrailerLayout(size, int64(h.ChunkSize))
+	} else {
+		err = r.readLayout(size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readTrailerLayout computes the position of every chunk from the chunk size
+// in the header and the sizes in the trailer. The final chunk is authenticated
+// with the trailer, so the sizes are verified before returning.
+func (r *ReaderAt) readTrailerLayout(size, chunkSize int64) error {
+	end := make([]byte, 4+trailerSize)
+	if err := r.readFull(end, size-int64(len(end))); err != nil {
+		return fmt.Errorf("reading trailer: %w", err)
+	}
+	if binary.BigEndian.Uint32(end[:4]) != 0 {
+		return errors.New("missing end marker")
+	}
+	r.trailer = end[4:]
+	t := parseTrailer(r.trailer)
+
+	overhead := int64(4 + nonceSize + r.aead.Overhead())
+	body := size - r.dataOffset - int64(len(end))
+	if body < overhead || t.CiphertextSize != uint64(body) || t.PlaintextSize > uint64(body) { //nolint:gosec // body is positive
+		return errors.New("trailer sizes don't match the stream")
+	}
+
+	r.size = int64(t.PlaintextSize) //nolint:gosec // checked against body
+	r.chunkSize = chunkSize
+	r.stride = overhead + chunkSize
+	r.chunks = (r.size + chunkSize - 1) / chunkSize
+	var dataLen int64
+	if r.chunks > 0 {
+		r.lastLen = overhead + r.size - (r.chunks-1)*chunkSize
+		dataLen = (r.chunks-1)*r.stride + r.lastLen
+	}
+	// The final chunk holds the digest
+	r.finalLen = body - dataLen
+	if r.finalLen != overhead+digestSize {
+		return errors.New("trailer sizes don't match the stream")
+	}
+	if uint64(r.chunks) >= maxChunks { //nolint:gosec // chunks is positive
+		return errors.New("maximum chunk count exceeded to prevent nonce reuse")
+	}
+
+	// The final chunk follows the data chunks
+	if _, err := r.chunk(r.chunks); err != nil {
+		return err
+	}
+	if r.chunks > 0 {
+		if _, err := r.chunk(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLayout computes the position of every chunk of version 1 streams from the
+// length of the first one. All chunks but the last hold the same amount
+// of plaintext, so they're stored with the same length.
+func (r *ReaderAt) readLayout(size int64) error {
+	body := size - r.dataOffset - 4
+	if body < 0 {
+		return fmt.Errorf("unexpected end of stream: %w", io.ErrUnexpectedEOF)
+	}
+
+	var marker [4]byte
+	if err := r.readFull(marker[:], size-4); err != nil {
+		return fmt.Errorf("reading end marker: %w", err)
+	}
+	if binary.BigEndian.Uint32(marker[:]) != 0 {
+		return errors.New("missing end marker")
+	}
+
+	if body == 0 {
+		return nil
+	}
+
+	var lenBuf [4]byte
+	if err := r.readFull(lenBuf[:], r.dataOffset); err != nil {
+		return fmt.Errorf("reading chunk length: %w", err)
+	}
+	overhead := int64(4 + nonceSize + r.aead.Overhead())
+	r.stride = 4 + int64(binary.BigEndian.Uint32(lenBuf[:]))
+	if r.stride < overhead || r.stride > body {
+		return errors.New("invalid chunk 0 length")
+	}
+
+	r.chunks = (body + r.stride - 1) / r.stride
+	r.lastLen = body - (r.chunks-1)*r.stride
+	if r.lastLen < overhead {
+		return errors.New("invalid length of last chunk")
+	}
+	if uint64(r.chunks) > maxChunks { //nolint:gosec // chunks is positive
+		return errors.New("maximum chunk count exceeded to prevent nonce reuse")
+	}
+	r.chunkSize = r.stride - overhead
+	r.size = (r.chunks-1)*r.chunkSize + r.lastLen - overhead
+
+	// Authenticate the header, which is bound to chunk 0, and the last chunk so
+	// its length is verified before reading.
+	if _, err := r.chunk(0); err != nil {
+		return err
+	}
+	if r.chunks > 1 {
+		if _, err := r.chunk(r.chunks - 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ReaderAt) readFull(p []byte, off int64) error {
+	n, err := r.src.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// chunk returns the plaintext of chunk i. The returned slice must not be modified.
+func (r *ReaderAt) chunk(i int64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cachedIndex == i {
+		return r.cached, nil
+	}
+
+	length := r.stride
+	last := i == r.chunks-1
+	if last {
+		length = r.lastLen
+	}
+	var aad []byte
+	if i == 0 {
+		aad = r.headerAAD
+	}
+	if r.trailer != nil {
+		// Data chunks are never final from version 3. The final chunk follows them
+		// and authenticates the trailer.
+		last = i == r.chunks
+		if last {
+			length = r.finalLen
+			aad = append(aad[:len(aad):len(aad)], r.trailer...)
+		}
+	}
+
+	offset := r.dataOffset + i*r.stride
+	if i > 0 && i == r.chunks {
+		offset = r.dataOffset + (i-1)*r.stride + r.lastLen
+	}
+
+	frame := make([]byte, length)
+	if err := r.readFull(frame, offset); err != nil {
+		return nil, fmt.Errorf("reading chunk %d: %w", i, err)
+	}
+	if int64(binary.BigEndian.Uint32(frame[:4])) != length-4 {
+		if last && r.trailer != nil {
+			return nil, fmt.Errorf("unexpected length of final chunk %d", i)
+		}
+		return nil, fmt.Errorf("unexpected length of chunk %d", i)
+	}
+	chunk := frame[4:]
+
+	// Verify nonce counter, and that only the last chunk is marked as final
+	counter := uint64(i) //nolint:gosec // i is within [0, chunks)
+	expectedNonce := buildNonce(r.noncePrefix, counter)
+	if last && r.version != formatVersion1 {
+		expectedNonce = buildFinalNonce(r.noncePrefix, counter)
+	}
+	var actualNonce [nonceSize]byte
+	copy(actualNonce[:], chunk[:nonceSize])
+	if i == 0 {
+		actualNonce = clearRewrapEpoch(actualNonce)
+	}
+	if actualNonce != expectedNonce {
+		if last && actualNonce == buildNonce(r.noncePrefix, counter) {
+			return nil, fmt.Errorf("chunk %d is not final: %w", i, ErrTruncated)
+		}
+		return nil, fmt.Errorf("nonce counter mismatch at chunk %d", i)
+	}
+
+	plaintext, err := r.aead.Open(nil, chunk[:nonceSize], chunk[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting chunk %d: %w", i, err)
+	}
+
+	r.cachedIndex, r.cached = i, plaintext
+	return plaintext, nil
+}
+
+// Metadata returns the metadata stored in the header with WithMetadata, or nil when
+// there is none. It has been authenticated by NewReaderAt.
+func (r *ReaderAt) Metadata() map[string]string {
+	return maps.Clone(r.metadata)
+}
+
+// Size returns the plaintext size of the stream.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes of plaintext starting at off.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("stream: negative offset")
+	}
+
+	n := 0
+	for n < len(p) && off < r.size {
+		i := off / r.chunkSize
+		plaintext, err := r.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plaintext[off-i*r.chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads plaintext from the current offset.
+func (r *ReaderAt) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read, interpreted according to whence.
+func (r *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("stream: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("stream: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+var _ io.ReaderAt = (&ReaderAt{})
+var _ io.ReadSeeker = (&ReaderAt{})
@@ -0,0 +1,175 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderAt(t *testing.T) {
+	key := []byte("1234567890123456")
+	kp := NewStaticKeyProvider(key)
+
+	original := make([]byte, 1000)
+	_, err := rand.Read(original)
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, 99, 100, 101, 1000} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, kp, WithChunkSize(100))
+			require.NoError(t, err)
+			_, err = w.Write(original[:size])
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			r, err := NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), kp)
+			require.NoError(t, err)
+			require.Equal(t, int64(size), r.Size())
+
+			require.NoError(t, iotest.TestReader(r, original[:size]))
+
+			// Every range across chunk boundaries
+			for _, off := range []int{0, 1, 50, 99, 100, 150, 999} {
+				for _, n := range []int{1, 99, 100, 101, 250} {
+					p := make([]byte, n)
+					got, err := r.ReadAt(p, int64(off))
+					if off+n <= size {
+						require.NoError(t, err)
+						require.Equal(t, n, got)
+						require.Equal(t, original[off:off+n], p)
+					} else {
+						require.ErrorIs(t, err, io.EOF)
+						require.Equal(t, max(size-off, 0), got)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("Seek", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, WithChunkSize(64))
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		r, err := NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), kp)
+		require.NoError(t, err)
+
+		pos, err := r.Seek(-10, io.SeekEnd)
+		require.NoError(t, err)
+		require.Equal(t, int64(990), pos)
+
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original[990:], got)
+
+		_, err = r.Seek(-1, io.SeekStart)
+		require.Error(t, err)
+
+		_, err = r.Seek(500, io.SeekStart)
+		require.NoError(t, err)
+		pos, err = r.Seek(-100, io.SeekCurrent)
+		require.NoError(t, err)
+		require.Equal(t, int64(400), pos)
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		for _, size := range []int{0, 1, 100, 1000} {
+			buf := writeVersionedTestData(t, formatVersion1, key, original[:size], false, 100)
+
+			r, err := NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), kp, WithLegacyV1())
+			require.NoError(t, err)
+			require.Equal(t, int64(size), r.Size())
+			require.NoError(t, iotest.TestReader(r, original[:size]))
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, WithCompression())
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		_, err = NewReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), kp)
+		require.ErrorContains(t, err, "uncompressed")
+	})
+}
+
+func TestReaderAtCorruptionDetection(t *testing.T) {
+	key := []byte("1234567890123456")
+	kp := NewStaticKeyProvider(key)
+	original := []byte("The quick brown fox jumps over the lazy dog")
+
+	newReaderAt := func(data []byte) (*ReaderAt, error) {
+		return NewReaderAt(bytes.NewReader(data), int64(len(data)), kp)
+	}
+
+	t.Run("tampered header", func(t *testing.T) {
+		data := writeTestData(t, key, original, false, 10).Bytes()
+		data[5] ^= 0x80 // unused flag bit
+
+		_, err := newReaderAt(data)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+
+	t.Run("dropped chunks", func(t *testing.T) {
+		buf := writeTestData(t, key, original, false, 10)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+
+		// Sizes in the trailer no longer match
+		_, err := newReaderAt(joinChunks(header, chunks[:3], tail))
+		require.ErrorContains(t, err, "trailer sizes")
+
+		// Final chunk dropped
+		_, err = newReaderAt(joinChunks(header, chunks[:len(chunks)-1], tail))
+		require.ErrorContains(t, err, "trailer sizes")
+	})
+
+	t.Run("reordered chunks", func(t *testing.T) {
+		buf := writeTestData(t, key, original, false, 10)
+		header, chunks, tail := splitChunks(t, buf.Bytes())
+		chunks[1], chunks[2] = chunks[2], chunks[1]
+
+		r, err := newReaderAt(joinChunks(header, chunks, tail))
+		require.NoError(t, err)
+
+		_, err = r.ReadAt(make([]byte, 5), 15)
+		require.ErrorContains(t, err, "nonce counter mismatch at chunk 1")
+	})
+
+	t.Run("tampered chunk", func(t *testing.T) {
+		data := writeTestData(t, key, original, false, 10).Bytes()
+		data[headerSize(t, data)+42+4+nonceSize] ^= 0xFF // second chunk
+
+		r, err := newReaderAt(data)
+		require.NoError(t, err)
+
+		_, err = r.ReadAt(make([]byte, 5), 0)
+		require.NoError(t, err)
+		_, err = r.ReadAt(make([]byte, 5), 10)
+		require.ErrorContains(t, err, "decrypting chunk 1")
+	})
+
+	t.Run("missing end marker", func(t *testing.T) {
+		data := writeTestData(t, key, original, false, 10).Bytes()
+
+		_, err := newReaderAt(data[:len(data)-4])
+		require.Error(t, err)
+
+		_, err = newReaderAt(data[:len(data)-trailerSize])
+		require.Error(t, err)
+
+		_, err = newReaderAt(data[:headerSize(t, data)])
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,172 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// wrappingKeyProvider wraps data keys with AES-GCM under its kek.
+type wrappingKeyProvider struct {
+	id  string
+	kek []byte
+
+	unwraps int
+}
+
+func newWrappingKeyProvider(t *testing.T, id string) *wrappingKeyProvider {
+	t.Helper()
+
+	kek := make([]byte, 32)
+	_, err := rand.Read(kek)
+	require.NoError(t, err)
+	return &wrappingKeyProvider{id: id, kek: kek}
+}
+
+func (p *wrappingKeyProvider) ProviderID() string {
+	return p.id
+}
+
+func (p *wrappingKeyProvider) gcm() cipher.AEAD {
+	block, _ := aes.NewCipher(p.kek)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+func (p *wrappingKeyProvider) GenerateKey() (*DataKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := p.WrapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{Plaintext: key, WrappedKey: wrapped}, nil
+}
+
+func (p *wrappingKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return p.gcm().Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (p *wrappingKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	p.unwraps++
+	if len(wrappedKey) < 12 {
+		return nil, errors.New("wrapped key too short")
+	}
+	return p.gcm().Open(nil, wrappedKey[:12], wrappedKey[12:], nil)
+}
+
+// anonymousKeyProvider hides the ProviderID of a wrappingKeyProvider.
+type anonymousKeyProvider struct {
+	*wrappingKeyProvider
+}
+
+func (anonymousKeyProvider) ProviderID() string {
+	return ""
+}
+
+func TestRecipients(t *testing.T) {
+	original := []byte("The quick brown fox jumps over the lazy dog")
+
+	primary := newWrappingKeyProvider(t, "primary")
+	dr := newWrappingKeyProvider(t, "dr")
+	breakGlass := newWrappingKeyProvider(t, "break-glass")
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, primary, WithRecipients(dr, breakGlass))
+	require.NoError(t, err)
+	_, err = w.Write(original)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data := buf.Bytes()
+
+	read := func(kp KeyProvider, data []byte) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(data), kp)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	t.Run("Inspect", func(t *testing.T) {
+		info, err := Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Empty(t, info.WrappedKey)
+		require.Len(t, info.Stanzas, 3)
+		require.Equal(t, "primary", info.Stanzas[0].ProviderID)
+		require.Equal(t, "dr", info.Stanzas[1].ProviderID)
+		require.Equal(t, "break-glass", info.Stanzas[2].ProviderID)
+	})
+
+	t.Run("each recipient", func(t *testing.T) {
+		for _, kp := range []*wrappingKeyProvider{primary, dr, breakGlass} {
+			kp.unwraps = 0
+
+			got, err := read(kp, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+
+			// Only the stanza with the provider's ID is tried
+			require.Equal(t, 1, kp.unwraps)
+		}
+	})
+
+	t.Run("without provider ID", func(t *testing.T) {
+		kp := anonymousKeyProvider{breakGlass}
+		kp.unwraps = 0
+
+		got, err := read(kp, data)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+		require.Equal(t, 3, kp.unwraps)
+	})
+
+	t.Run("ReaderAt", func(t *testing.T) {
+		r, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), dr)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("not a recipient", func(t *testing.T) {
+		_, err := read(newWrappingKeyProvider(t, "other"), data)
+		require.ErrorContains(t, err, `no key stanza for provider "other"`)
+
+		_, err = read(anonymousKeyProvider{newWrappingKeyProvider(t, "")}, data)
+		require.ErrorContains(t, err, "key stanza 2")
+	})
+
+	t.Run("stanzas are bound to the header", func(t *testing.T) {
+		tampered := bytes.Clone(data)
+		info, err := Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		// Corrupt the last byte of the last stanza, which primary doesn't read
+		tampered[info.HeaderSize-1] ^= 0xFF
+
+		_, err = read(primary, tampered)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+
+	t.Run("primary without wrapped key", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, NewStaticKeyProvider([]byte("1234567890123456")), WithRecipients(dr))
+		require.ErrorContains(t, err, "recipients require a KeyProvider which wraps data keys")
+	})
+
+	t.Run("recipient without KeyWrapper", func(t *testing.T) {
+		_, err := NewWriter(io.Discard, primary, WithRecipients(NewStaticKeyProvider([]byte("1234567890123456"))))
+		require.ErrorContains(t, err, "does not implement KeyWrapper")
+	})
+}
@@ -0,0 +1,195 @@
+package stream
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Rewrap copies the CRFS stream in src to dst with its data key unwrapped by oldKP
+// and wrapped again by newKP, which must implement KeyWrapper. Only the header and
+// chunk 0, which authenticates the header, are rewritten. The remaining chunks are
+// copied without being decrypted, so rotating a key doesn't require re-encrypting
+// the stream.
+//
+// oldKP and newKP may be the same provider, such as a Vault transit key which has been
+// rotated to a new version. In a stream with several recipients only the key stanzas
+// oldKP unwraps are replaced, the other recipients' stanzas are copied unchanged.
+// WithRecipients adds key stanzas for other providers, other options are ignored.
+//
+// Only version 3 streams can be rewrapped, older versions must be decrypted and
+// written again. Rewrap only authenticates chunk 0, the rest of the stream is
+// verified when dst is read. Rewrapped streams can't be read by releases from before
+// Rewrap was added.
+func Rewrap(src io.Reader, dst io.Writer, oldKP, newKP KeyProvider, opts ...Option) error {
+	return RewrapContext(context.Background(), src, dst, oldKP, newKP, opts...)
+}
+
+// RewrapContext is like Rewrap but passes ctx to the KeyProviders.
+func RewrapContext(ctx context.Context, src io.Reader, dst io.Writer, oldKP, newKP KeyProvider, opts ...Option) error {
+	o := options{}
+	for _, fn := range opts {
+		fn(&o)
+	}
+
+	h, aad, err := readHeader(src)
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+
+	// The rewrap flag in chunk 0's nonce is unknown to readers of older versions,
+	// so rewrapped streams must declare a version which has it.
+	if h.Version < formatVersion3 {
+		return fmt.Errorf("rewrapping requires format version %d, stream is version %d", formatVersion3, h.Version)
+	}
+
+	var key []byte
+	var opened []bool // stanzas unwrapped by oldKP
+	if len(h.Stanzas) > 0 {
+		key, opened, err = openStanzas(ctx, oldKP, h.Stanzas)
+	} else {
+		key, err = headerKey(ctx, oldKP, h)
+	}
+	if err != nil {
+		return err
+	}
+
+	wk, err := wrapKey(ctx, newKP, key)
+	if err != nil {
+		return fmt.Errorf("wrapping data key: %w", err)
+	}
+	if len(wk) == 0 {
+		return errors.New("rewrapping requires a KeyProvider which wraps data keys")
+	}
+
+	nh := *h
+	nh.WrappedKey, nh.Stanzas = wk, nil
+	if len(h.Stanzas) > 0 {
+		// newKP's stanza takes the place of the first one oldKP unwrapped
+		nh.WrappedKey = nil
+		replaced := false
+		for i, st := range h.Stanzas {
+			switch {
+			case !opened[i]:
+				nh.Stanzas = append(nh.Stanzas, st)
+			case !replaced:
+				nh.Stanzas = append(nh.Stanzas, Stanza{ProviderID: providerID(newKP), WrappedKey: wk})
+				replaced = true
+			}
+		}
+	}
+	if len(o.recipients) > 0 {
+		stanzas, err := recipientStanzas(ctx, newKP, &DataKey{Plaintext: key, WrappedKey: wk}, o.recipients)
+		if err != nil {
+			return err
+		}
+		if nh.WrappedKey != nil {
+			nh.Stanzas = stanzas
+			nh.WrappedKey = nil
+		} else {
+			nh.Stanzas = append(nh.Stanzas, stanzas[1:]...)
+		}
+	}
+	if err := validateHeader(&nh); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(key, h)
+	if err != nil {
+		return err
+	}
+
+	// Read and authenticate chunk 0 under the original header
+	cr := &chunkReader{
+		src:         src,
+		aead:        aead,
+		noncePrefix: h.NoncePrefix,
+		headerAAD:   aad,
+		version:     h.Version,
+	}
+	c, err := cr.readChunk()
+	if err != nil {
+		return err
+	}
+
+	if err := writeHeader(dst, &nh); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+
+	plaintext, err := cr.open(c)
+	if err != nil {
+		return err
+	}
+	chunk, err := resealChunk(aead, &nh, plaintext, c.trailer != nil, c.trailer)
+	if err != nil {
+		return err
+	}
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(chunk))) //nolint:gosec // same length as the original chunk
+	frame = append(frame, chunk...)
+	if c.trailer != nil {
+		// chunk 0 was the final chunk of an empty stream
+		frame = append(frame, 0, 0, 0, 0)
+		frame = append(frame, c.trailer...)
+	}
+	if _, err := dst.Write(frame); err != nil {
+		return fmt.Errorf("writing chunk 0: %w", err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("copying chunks: %w", err)
+	}
+	return nil
+}
+
+// resealChunk encrypts plaintext as chunk 0 of a stream with header h. The nonce has
+// rewrapFlag and a random epoch so it differs from the nonce chunk 0 was sealed with.
+func resealChunk(aead cipher.AEAD, h *fileHeader, plaintext []byte, final bool, trailer []byte) ([]byte, error) {
+	var epoch [8]byte
+	if _, err := rand.Read(epoch[:]); err != nil {
+		return nil, fmt.Errorf("generating rewrap epoch: %w", err)
+	}
+	nonce := buildRewrapNonce(h.NoncePrefix, binary.BigEndian.Uint64(epoch[:]))
+	if final {
+		nonce[noncePrefixSize] |= finalChunkFlag
+	}
+
+	aad := headerBytes(h)
+	if len(trailer) > 0 {
+		aad = append(aad, trailer...)
+	}
+
+	chunk := make([]byte, nonceSize, nonceSize+len(plaintext)+aead.Overhead())
+	copy(chunk, nonce[:])
+	return aead.Seal(chunk, nonce[:], plaintext, aad), nil
+}
+
+// openStanzas unwraps the data key with kp and reports which stanzas it unwrapped.
+// Beyond the first, the stanzas with kp's ID which unwrap to the same key are kp's
+// too, such as when it was added as a recipient twice.
+func openStanzas(ctx context.Context, kp KeyProvider, stanzas []Stanza) ([]byte, []bool, error) {
+	key, first, err := stanzaKey(ctx, kp, stanzas)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id := providerID(kp)
+	opened := make([]bool, len(stanzas))
+	opened[first] = true
+	for i := first + 1; i < len(stanzas); i++ {
+		if id != "" && stanzas[i].ProviderID != id {
+			continue
+		}
+		other, err := unwrapKey(ctx, kp, stanzas[i].WrappedKey)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, fmt.Errorf("unwrapping data key: %w", ctxErr)
+		}
+		opened[i] = err == nil && subtle.ConstantTimeCompare(other, key) == 1
+	}
+	return key, opened, nil
+}
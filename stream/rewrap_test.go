@@ -0,0 +1,194 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrap(t *testing.T) {
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100)
+
+	write := func(t *testing.T, kp KeyProvider, data []byte, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	rewrap := func(t *testing.T, data []byte, oldKP, newKP KeyProvider, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		require.NoError(t, Rewrap(bytes.NewReader(data), &buf, oldKP, newKP, opts...))
+		return buf.Bytes()
+	}
+	read := func(kp KeyProvider, data []byte) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(data), kp)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	oldKP := newWrappingKeyProvider(t, "old")
+	newKP := newWrappingKeyProvider(t, "new")
+
+	t.Run("new provider", func(t *testing.T) {
+		data := write(t, oldKP, original, WithChunkSize(1000), WithCompression())
+		rewrapped := rewrap(t, data, oldKP, newKP)
+
+		got, err := read(newKP, rewrapped)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+
+		_, err = read(oldKP, rewrapped)
+		require.ErrorContains(t, err, "unwrapping data key")
+
+		// Only the header and chunk 0 change
+		_, oldChunks, oldTail := splitChunks(t, data)
+		_, newChunks, newTail := splitChunks(t, rewrapped)
+		require.Len(t, newChunks, len(oldChunks))
+		require.NotEqual(t, oldChunks[0], newChunks[0])
+		require.Equal(t, oldChunks[1:], newChunks[1:])
+		require.Equal(t, oldTail, newTail)
+
+		info, err := Inspect(bytes.NewReader(rewrapped))
+		require.NoError(t, err)
+		require.True(t, info.Compressed)
+		require.Equal(t, 1000, info.ChunkSize)
+	})
+
+	t.Run("same provider", func(t *testing.T) {
+		data := write(t, oldKP, original)
+		rewrapped := rewrap(t, data, oldKP, oldKP)
+		again := rewrap(t, rewrapped, oldKP, oldKP)
+
+		// Each rewrap seals chunk 0 under a new nonce
+		nonces := make(map[string]bool)
+		for _, d := range [][]byte{data, rewrapped, again} {
+			_, chunks, _ := splitChunks(t, d)
+			nonces[string(chunks[0][:nonceSize])] = true
+		}
+		require.Len(t, nonces, 3)
+
+		got, err := read(oldKP, again)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("ReaderAt", func(t *testing.T) {
+		data := rewrap(t, write(t, oldKP, original, WithChunkSize(1000)), oldKP, newKP)
+
+		r, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), newKP)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		data := rewrap(t, write(t, oldKP, nil), oldKP, newKP)
+
+		got, err := read(newKP, data)
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		key := []byte("1234567890123456")
+
+		// Older readers don't know the rewrap flag, so v1 streams aren't rewrapped
+		buf := writeVersionedTestData(t, formatVersion1, key, original, false, 1000)
+		err := Rewrap(bytes.NewReader(buf.Bytes()), io.Discard, NewStaticKeyProvider(key), newKP)
+		require.ErrorContains(t, err, "rewrapping requires format version 3")
+	})
+
+	t.Run("recipients", func(t *testing.T) {
+		dr := newWrappingKeyProvider(t, "dr")
+		data := rewrap(t, write(t, oldKP, original), oldKP, newKP, WithRecipients(dr))
+
+		for _, kp := range []KeyProvider{newKP, dr} {
+			got, err := read(kp, data)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+	})
+
+	t.Run("keeps other recipients", func(t *testing.T) {
+		dr := newWrappingKeyProvider(t, "dr")
+		breakGlass := newWrappingKeyProvider(t, "break-glass")
+		data := write(t, oldKP, original, WithRecipients(dr, breakGlass))
+
+		before, err := Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Len(t, before.Stanzas, 3)
+
+		rewrapped := rewrap(t, data, oldKP, newKP)
+		after, err := Inspect(bytes.NewReader(rewrapped))
+		require.NoError(t, err)
+		require.Len(t, after.Stanzas, 3)
+		require.Equal(t, before.Stanzas[1:], after.Stanzas[1:])
+
+		for _, kp := range []KeyProvider{newKP, dr, breakGlass} {
+			got, err := read(kp, rewrapped)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+		_, err = read(oldKP, rewrapped)
+		require.ErrorContains(t, err, `no key stanza for provider "old"`)
+
+		// Added recipients follow the kept stanzas
+		audit := newWrappingKeyProvider(t, "audit")
+		rewrapped = rewrap(t, data, oldKP, newKP, WithRecipients(audit))
+		after, err = Inspect(bytes.NewReader(rewrapped))
+		require.NoError(t, err)
+		require.Len(t, after.Stanzas, 4)
+		for _, kp := range []KeyProvider{newKP, dr, breakGlass, audit} {
+			got, err := read(kp, rewrapped)
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		data := write(t, oldKP, original)
+
+		err := Rewrap(bytes.NewReader(data), io.Discard, newKP, newKP)
+		require.ErrorContains(t, err, "unwrapping data key")
+
+		static := write(t, NewStaticKeyProvider([]byte("1234567890123456")), original)
+		err = Rewrap(bytes.NewReader(static), io.Discard, NewStaticKeyProvider([]byte("6543210987654321")), newKP)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+
+	t.Run("without KeyWrapper", func(t *testing.T) {
+		data := write(t, oldKP, original)
+
+		err := Rewrap(bytes.NewReader(data), io.Discard, oldKP, NewStaticKeyProvider([]byte("1234567890123456")))
+		require.ErrorContains(t, err, "does not implement KeyWrapper")
+	})
+
+	t.Run("rewrap flag is authenticated", func(t *testing.T) {
+		data := rewrap(t, write(t, oldKP, original), oldKP, newKP)
+		size := headerSize(t, data)
+
+		// Clearing the flag leaves the epoch, which isn't counter 0
+		tampered := bytes.Clone(data)
+		tampered[size+4+noncePrefixSize] &^= rewrapFlag
+		_, err := read(newKP, tampered)
+		require.ErrorContains(t, err, "nonce counter mismatch at chunk 0")
+
+		// Changing the epoch fails decryption
+		tampered = bytes.Clone(data)
+		tampered[size+4+nonceSize-1] ^= 0x01
+		_, err = read(newKP, tampered)
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+}
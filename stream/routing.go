@@ -0,0 +1,178 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Route is one of the KeyProviders of a routing provider.
+type Route struct {
+	// Name identifies the route in RoutingConfig.Write, to Observe and in errors.
+	Name string
+
+	// Prefixes of the wrapped keys unwrapped by Provider, such as "vault:v1:" for Vault
+	// transit or "keyring:" for a Keyring. A route without prefixes is tried in order
+	// with the others for wrapped keys which match no prefix.
+	Prefixes []string
+
+	Provider KeyProvider
+}
+
+// RoutingConfig configures NewRoutingKeyProvider.
+type RoutingConfig struct {
+	// Write is the name of the route which generates and wraps data keys.
+	Write string
+
+	Routes []Route
+
+	// Observe is called after each unwrap with the name of the route which unwrapped
+	// the key, or with an empty name and the error when no route could. It may be
+	// called concurrently.
+	Observe func(route string, err error)
+}
+
+// NewRoutingKeyProvider returns a KeyProvider which writes with one provider and reads
+// with several, such as while migrating from Vault to a local KEK. Wrapped keys are
+// unwrapped by the route with the longest matching prefix, otherwise by the first of
+// the routes without prefixes which succeeds.
+//
+// The provider has no ProviderID, so NewReader offers it every key stanza of a stream.
+// WrapKey, used by WithRecipients and Rewrap, requires the write route's provider to
+// implement KeyWrapper. Streams without a wrapped key are read with the first route
+// holding a static key, from NewStaticKeyProvider or a Keyring with a LegacyKey.
+func NewRoutingKeyProvider(conf RoutingConfig) (KeyProvider, error) {
+	p := &routingKeyProvider{
+		routes:  conf.Routes,
+		observe: conf.Observe,
+	}
+	names := make(map[string]bool, len(conf.Routes))
+	for i, r := range conf.Routes {
+		if r.Name == "" || names[r.Name] {
+			return nil, fmt.Errorf("route %d: missing or duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+		if r.Provider == nil {
+			return nil, fmt.Errorf("route %s: missing KeyProvider", r.Name)
+		}
+		if r.Name == conf.Write {
+			p.write = r
+		}
+		for _, prefix := range r.Prefixes {
+			if prefix == "" {
+				return nil, fmt.Errorf("route %s: empty prefix", r.Name)
+			}
+		}
+	}
+	if p.write.Provider == nil {
+		return nil, fmt.Errorf("write route %q not found", conf.Write)
+	}
+	return p, nil
+}
+
+type routingKeyProvider struct {
+	write   Route
+	routes  []Route
+	observe func(route string, err error)
+}
+
+func (p *routingKeyProvider) GenerateKey() (*DataKey, error) {
+	return p.GenerateKeyContext(context.Background())
+}
+
+func (p *routingKeyProvider) GenerateKeyContext(ctx context.Context) (*DataKey, error) {
+	dk, err := generateKey(ctx, p.write.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", p.write.Name, err)
+	}
+	return dk, nil
+}
+
+func (p *routingKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	return p.WrapKeyContext(context.Background(), plaintext)
+}
+
+func (p *routingKeyProvider) WrapKeyContext(ctx context.Context, plaintext []byte) ([]byte, error) {
+	wk, err := wrapKey(ctx, p.write.Provider, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", p.write.Name, err)
+	}
+	return wk, nil
+}
+
+func (p *routingKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	return p.UnwrapKeyContext(context.Background(), wrappedKey)
+}
+
+func (p *routingKeyProvider) UnwrapKeyContext(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	route, key, err := p.unwrap(ctx, wrappedKey)
+	if p.observe != nil {
+		p.observe(route, err)
+	}
+	return key, err
+}
+
+func (p *routingKeyProvider) staticKey(ctx context.Context) ([]byte, error) {
+	for _, r := range p.routes {
+		if skh, ok := r.Provider.(staticKeyHolder); ok {
+			key, err := skh.staticKey(ctx)
+			if err != nil {
+				continue
+			}
+			if p.observe != nil {
+				p.observe(r.Name, nil)
+			}
+			return key, nil
+		}
+	}
+	err := errors.New("no route holds a key for streams without a wrapped key")
+	if p.observe != nil {
+		p.observe("", err)
+	}
+	return nil, err
+}
+
+// unwrap returns the name of the route which unwrapped wrappedKey and the key.
+func (p *routingKeyProvider) unwrap(ctx context.Context, wrappedKey []byte) (string, []byte, error) {
+	if r, ok := p.prefixRoute(wrappedKey); ok {
+		key, err := unwrapKey(ctx, r.Provider, wrappedKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("route %s: %w", r.Name, err)
+		}
+		return r.Name, key, nil
+	}
+
+	var errs []error
+	for _, r := range p.routes {
+		if len(r.Prefixes) > 0 {
+			continue
+		}
+		key, err := unwrapKey(ctx, r.Provider, wrappedKey)
+		if err == nil {
+			return r.Name, key, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", nil, ctxErr
+		}
+		errs = append(errs, fmt.Errorf("route %s: %w", r.Name, err))
+	}
+	if len(errs) == 0 {
+		return "", nil, errors.New("no route for wrapped key")
+	}
+	return "", nil, errors.Join(errs...)
+}
+
+// prefixRoute returns the route with the longest prefix of wrappedKey.
+func (p *routingKeyProvider) prefixRoute(wrappedKey []byte) (Route, bool) {
+	var match Route
+	longest := 0
+	for _, r := range p.routes {
+		for _, prefix := range r.Prefixes {
+			if len(prefix) > longest && strings.HasPrefix(string(wrappedKey), prefix) {
+				match, longest = r, len(prefix)
+			}
+		}
+	}
+	return match, longest > 0
+}
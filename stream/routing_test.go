@@ -0,0 +1,198 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// prefixKeyProvider prefixes the wrapped keys of a wrappingKeyProvider, like the
+// "vault:v1:" of Vault transit.
+type prefixKeyProvider struct {
+	*wrappingKeyProvider
+	prefix string
+}
+
+func (p *prefixKeyProvider) GenerateKey() (*DataKey, error) {
+	dk, err := p.wrappingKeyProvider.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	dk.WrappedKey = append([]byte(p.prefix), dk.WrappedKey...)
+	return dk, nil
+}
+
+func (p *prefixKeyProvider) WrapKey(plaintext []byte) ([]byte, error) {
+	wk, err := p.wrappingKeyProvider.WrapKey(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(p.prefix), wk...), nil
+}
+
+func (p *prefixKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(wrappedKey, []byte(p.prefix))
+	if !ok {
+		return nil, errors.New("missing prefix")
+	}
+	return p.wrappingKeyProvider.UnwrapKey(rest)
+}
+
+func TestRoutingKeyProvider(t *testing.T) {
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)
+
+	write := func(t *testing.T, kp KeyProvider, opts ...Option) []byte {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+	read := func(kp KeyProvider, data []byte) ([]byte, error) {
+		r, err := NewReader(bytes.NewReader(data), kp)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	vault := &prefixKeyProvider{wrappingKeyProvider: newWrappingKeyProvider(t, "vault"), prefix: "vault:v1:"}
+	kwp, err := NewKeyWrapProvider(bytes.Repeat([]byte("k"), 32))
+	require.NoError(t, err)
+	kr, err := NewKeyring("2026", map[string][]byte{"2026": bytes.Repeat([]byte("r"), 32)})
+	require.NoError(t, err)
+	other := newWrappingKeyProvider(t, "other")
+
+	var mu sync.Mutex
+	var observed []string
+	kp, err := NewRoutingKeyProvider(RoutingConfig{
+		Write: "kwp",
+		Routes: []Route{
+			{Name: "vault", Prefixes: []string{"vault:v1:"}, Provider: vault},
+			{Name: "keyring", Prefixes: []string{"keyring:"}, Provider: kr},
+			{Name: "kwp", Provider: kwp},
+			{Name: "other", Provider: other},
+		},
+		Observe: func(route string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				route = "error"
+			}
+			observed = append(observed, route)
+		},
+	})
+	require.NoError(t, err)
+	reset := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		observed = nil
+	}
+
+	t.Run("routes", func(t *testing.T) {
+		for _, tc := range []struct {
+			writer KeyProvider
+			route  string
+		}{
+			{vault, "vault"},
+			{kr, "keyring"},
+			{kwp, "kwp"},
+			{other, "other"},
+			{kp, "kwp"},
+		} {
+			reset()
+			got, err := read(kp, write(t, tc.writer))
+			require.NoError(t, err)
+			require.Equal(t, original, got)
+			require.Equal(t, []string{tc.route}, observed)
+		}
+	})
+
+	t.Run("prefixed keys aren't tried elsewhere", func(t *testing.T) {
+		other := &prefixKeyProvider{wrappingKeyProvider: newWrappingKeyProvider(t, "vault"), prefix: "vault:v1:"}
+
+		reset()
+		_, err := read(kp, write(t, other))
+		require.ErrorContains(t, err, "route vault: ")
+		require.Equal(t, []string{"error"}, observed)
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		unknown := newWrappingKeyProvider(t, "unknown")
+		_, err := read(kp, write(t, unknown))
+		require.ErrorContains(t, err, "route kwp: unwrapping key: integrity check failed")
+		require.ErrorContains(t, err, "route other: ")
+
+		prefixed, err := NewRoutingKeyProvider(RoutingConfig{
+			Write:  "vault",
+			Routes: []Route{{Name: "vault", Prefixes: []string{"vault:v1:"}, Provider: vault}},
+		})
+		require.NoError(t, err)
+		_, err = prefixed.UnwrapKey([]byte("unknown"))
+		require.ErrorContains(t, err, "no route for wrapped key")
+	})
+
+	t.Run("recipients and rewrap", func(t *testing.T) {
+		data := write(t, kp, WithRecipients(vault))
+		got, err := read(vault, data)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+
+		// Migrate a Vault stream to the local KEK
+		var buf bytes.Buffer
+		require.NoError(t, Rewrap(bytes.NewReader(write(t, vault)), &buf, kp, kp))
+		got, err = read(kwp, buf.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("static keys", func(t *testing.T) {
+		staticKey := bytes.Repeat([]byte("s"), 32)
+		static := write(t, NewStaticKeyProvider(staticKey))
+
+		_, err := read(kp, static)
+		require.ErrorContains(t, err, "no route holds a key")
+
+		withStatic, err := NewRoutingKeyProvider(RoutingConfig{
+			Write: "kwp",
+			Routes: []Route{
+				{Name: "kwp", Provider: kwp},
+				{Name: "static", Provider: NewStaticKeyProvider(staticKey)},
+			},
+		})
+		require.NoError(t, err)
+		got, err := read(withStatic, static)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := NewReaderContext(ctx, bytes.NewReader(write(t, other)), kp)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, conf := range []RoutingConfig{
+			{Write: "a", Routes: []Route{{Name: "a", Provider: kwp}, {Name: "a", Provider: kwp}}},
+			{Write: "", Routes: []Route{{Name: "", Provider: kwp}}},
+			{Write: "a", Routes: []Route{{Name: "a"}}},
+			{Write: "a", Routes: []Route{{Name: "a", Prefixes: []string{""}, Provider: kwp}}},
+			{Write: "b", Routes: []Route{{Name: "a", Provider: kwp}}},
+		} {
+			_, err := NewRoutingKeyProvider(conf)
+			require.Error(t, err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package stream
+
+import (
+	"context"
+	"io"
+)
+
+// Verify reads the CRFS stream in src, authenticating every chunk, and returns the
+// SHA-256 digest and size of its plaintext. The plaintext is decrypted and
+// decompressed to compute the digest but isn't returned. src isn't closed.
+//
+// The digest is checked against the one stored by the Writer, so a stream which
+// verifies decrypts to the data originally written. Version 1 streams have no stored
+// digest, so their chunks are authenticated and the digest is only computed.
+// WithConcurrency decrypts chunks in parallel and WithLegacyV1 accepts version 1
+// streams, other options are ignored.
+func Verify(src io.Reader, kp KeyProvider, opts ...Option) ([]byte, int64, error) {
+	return VerifyContext(context.Background(), src, kp, opts...)
+}
+
+// VerifyContext is like Verify but passes ctx to the KeyProvider when unwrapping
+// the data key.
+func VerifyContext(ctx context.Context, src io.Reader, kp KeyProvider, opts ...Option) ([]byte, int64, error) {
+	// Hide any Close method, the Reader closes its source
+	r, err := NewReaderContext(ctx, struct{ io.Reader }{src}, kp, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return nil, n, err
+	}
+	return r.Digest(), n, nil
+}
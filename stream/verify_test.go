@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	kp := NewStaticKeyProvider(key)
+	original := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 500)
+	sum := sha256.Sum256(original)
+
+	write := func(t *testing.T, data []byte, opts ...Option) *Writer {
+		t.Helper()
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, opts...)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.Nil(t, w.Digest())
+		return w
+	}
+	closeWriter := func(t *testing.T, w *Writer) []byte {
+		t.Helper()
+
+		require.NoError(t, w.Close())
+		return w.chunks.dst.(*bytes.Buffer).Bytes()
+	}
+
+	for i, opts := range [][]Option{
+		{WithChunkSize(1000)},
+		{WithChunkSize(1000), WithConcurrency(4)},
+		{WithCompressionCodec(CodecZstd, 0)},
+		{WithCipher(XChaCha20Poly1305)},
+	} {
+		t.Run(fmt.Sprintf("options %d", i), func(t *testing.T) {
+			w := write(t, original, opts...)
+			data := closeWriter(t, w)
+			require.Equal(t, sum[:], w.Digest())
+
+			src := &closeRecorder{Reader: bytes.NewReader(data)}
+			digest, size, err := Verify(src, kp, opts...)
+			require.NoError(t, err)
+			require.Equal(t, sum[:], digest)
+			require.Equal(t, int64(len(original)), size)
+			require.False(t, src.closed)
+
+			r, err := NewReader(bytes.NewReader(data), kp)
+			require.NoError(t, err)
+			_, err = r.Read(make([]byte, 10))
+			require.NoError(t, err)
+			require.Nil(t, r.Digest())
+			_, err = io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, sum[:], r.Digest())
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		digest, size, err := Verify(bytes.NewReader(closeWriter(t, write(t, nil))), kp)
+		require.NoError(t, err)
+		empty := sha256.Sum256(nil)
+		require.Equal(t, empty[:], digest)
+		require.Zero(t, size)
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		w := write(t, original, WithChunkSize(1000))
+		w.hash.Write([]byte("more")) // the stored digest covers data which wasn't written
+		data := closeWriter(t, w)
+
+		_, _, err := Verify(bytes.NewReader(data), kp)
+		require.ErrorIs(t, err, ErrDigestMismatch)
+
+		r, err := NewReader(bytes.NewReader(data), kp)
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(t, err, ErrDigestMismatch)
+		require.Nil(t, r.Digest())
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		// Version 1 has no stored digest, so it's only computed
+		buf := writeVersionedTestData(t, formatVersion1, key, original, true, 1000)
+		digest, size, err := Verify(buf, kp, WithLegacyV1())
+		require.NoError(t, err)
+		require.Equal(t, sum[:], digest)
+		require.Equal(t, int64(len(original)), size)
+	})
+
+	t.Run("unexpected final chunk", func(t *testing.T) {
+		for _, digest := range [][]byte{nil, []byte("not a digest")} {
+			w := write(t, original, WithChunkSize(1000))
+			require.NoError(t, w.chunks.close(w.written, digest))
+			data := w.chunks.dst.(*bytes.Buffer).Bytes()
+
+			_, _, err := Verify(bytes.NewReader(data), kp)
+			require.ErrorContains(t, err, "final chunk does not hold a digest")
+
+			_, err = NewReaderAt(bytes.NewReader(data), int64(len(data)), kp)
+			require.ErrorContains(t, err, "trailer sizes don't match the stream")
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		data := closeWriter(t, write(t, original, WithChunkSize(1000)))
+		data[len(data)/2] ^= 0x01
+
+		_, _, err := Verify(bytes.NewReader(data), kp)
+		require.ErrorContains(t, err, "decrypting chunk")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		data := closeWriter(t, write(t, original))
+
+		_, _, err := Verify(bytes.NewReader(data), NewStaticKeyProvider(bytes.Repeat([]byte("2"), 32)))
+		require.ErrorContains(t, err, "decrypting chunk 0")
+	})
+}
+
+// closeRecorder records whether Close was called.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
@@ -1,38 +1,40 @@
 package stream
 
 import (
-	"compress/gzip"
-	"crypto/aes"
+	"context"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"slices"
+	"sync"
 )
 
 // chunkWriter buffers plaintext and encrypts full chunks with AES-GCM.
 type chunkWriter struct {
 	dst         io.Writer
-	gcm         cipher.AEAD
+	aead        cipher.AEAD
 	noncePrefix [noncePrefixSize]byte
 	headerAAD   []byte // serialized header, AAD (Additional Authorization Data) for first chunk only
 	buf         []byte
 	chunkSize   int
 	counter     uint64
+	written     uint64 // bytes of chunks written, including their length
+
+	// With a concurrency above one full chunks are queued in pending and
+	// encrypted in parallel once there are concurrency of them.
+	concurrency int
+	pending     [][]byte
 }
 
 func (cw *chunkWriter) Write(p []byte) (int, error) {
 	written := 0
 	for len(p) > 0 {
 		space := cw.chunkSize - len(cw.buf)
-		if space <= 0 {
-			if err := cw.flushChunk(); err != nil {
-				return written, err
-			}
-			continue
-		}
-
 		n := len(p)
 		if n > space {
 			n = space
@@ -42,7 +44,7 @@ func (cw *chunkWriter) Write(p []byte) (int, error) {
 		written += n
 
 		if len(cw.buf) >= cw.chunkSize {
-			if err := cw.flushChunk(); err != nil {
+			if err := cw.flushChunk(false, nil); err != nil {
 				return written, err
 			}
 		}
@@ -50,27 +52,97 @@ func (cw *chunkWriter) Write(p []byte) (int, error) {
 	return written, nil
 }
 
-func (cw *chunkWriter) flushChunk() error {
-	if len(cw.buf) == 0 {
+// flushChunk encrypts and writes the buffered plaintext. The final chunk is
+// always written, even when empty, so readers can tell the stream is complete.
+// trailer is added to the chunk's additional data.
+//
+// Chunks which aren't final are queued when writing concurrently.
+func (cw *chunkWriter) flushChunk(final bool, trailer []byte) error {
+	if len(cw.buf) == 0 && !final {
+		return nil
+	}
+
+	if cw.concurrency > 1 && !final {
+		cw.pending = append(cw.pending, cw.buf)
+		cw.buf = make([]byte, 0, cw.chunkSize)
+		if len(cw.pending) < cw.concurrency {
+			return nil
+		}
+		return cw.flushPending()
+	}
+
+	if err := cw.flushPending(); err != nil {
+		return err
+	}
+	chunk, err := cw.seal(cw.counter, cw.buf, final, trailer)
+	if err != nil {
+		return err
+	}
+	if err := cw.writeChunk(chunk); err != nil {
+		return err
+	}
+	cw.buf = cw.buf[:0]
+	return nil
+}
+
+// flushPending encrypts the queued chunks in parallel and writes them in order.
+func (cw *chunkWriter) flushPending() error {
+	if len(cw.pending) == 0 {
 		return nil
 	}
 
-	if cw.counter >= 1<<40 {
-		return errors.New("maximum chunk count exceeded to prevent nonce reuse")
+	chunks := make([][]byte, len(cw.pending))
+	errs := make([]error, len(cw.pending))
+	var wg sync.WaitGroup
+	for i, plaintext := range cw.pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunks[i], errs[i] = cw.seal(cw.counter+uint64(i), plaintext, false, nil) //nolint:gosec // i is never negative
+		}()
+	}
+	wg.Wait()
+	cw.pending = cw.pending[:0]
+
+	for i := range chunks {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if err := cw.writeChunk(chunks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seal encrypts plaintext as chunk number counter and returns the nonce and ciphertext.
+// It's safe to call concurrently.
+func (cw *chunkWriter) seal(counter uint64, plaintext []byte, final bool, trailer []byte) ([]byte, error) {
+	if counter >= maxChunks {
+		return nil, errors.New("maximum chunk count exceeded to prevent nonce reuse")
 	}
 
-	nonce := buildNonce(cw.noncePrefix, cw.counter)
+	nonce := buildNonce(cw.noncePrefix, counter)
+	if final {
+		nonce = buildFinalNonce(cw.noncePrefix, counter)
+	}
 
 	var aad []byte
-	if cw.counter == 0 {
+	if counter == 0 {
 		aad = cw.headerAAD
 	}
-
-	ciphertext := cw.gcm.Seal(nil, nonce[:], cw.buf, aad)
+	if len(trailer) > 0 {
+		aad = append(aad[:len(aad):len(aad)], trailer...)
+	}
 
 	// chunk = nonce + ciphertext (includes GCM tag)
-	chunk := append(nonce[:], ciphertext...)
+	chunk := make([]byte, nonceSize, nonceSize+len(plaintext)+cw.aead.Overhead())
+	copy(chunk, nonce[:])
+	return cw.aead.Seal(chunk, nonce[:], plaintext, aad), nil
+}
 
+// writeChunk writes the length and contents of the next chunk.
+func (cw *chunkWriter) writeChunk(chunk []byte) error {
 	// Write 4-byte chunk length
 	var lenBuf [4]byte
 	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(chunk))) //nolint:gosec // chunk size bounded by chunkSize + GCM overhead
@@ -82,21 +154,36 @@ func (cw *chunkWriter) flushChunk() error {
 		return fmt.Errorf("writing chunk data: %w", err)
 	}
 
-	cw.buf = cw.buf[:0]
 	cw.counter++
+	cw.written += uint64(len(lenBuf) + len(chunk))
 	return nil
 }
 
-func (cw *chunkWriter) Close() error {
-	// Flush any remaining buffered data
-	if err := cw.flushChunk(); err != nil {
+// close finishes the stream. plaintextSize is the number of bytes written
+// before compression, recorded in the trailer, and digest is their SHA-256 which
+// is encrypted in the final chunk.
+func (cw *chunkWriter) close(plaintextSize uint64, digest []byte) error {
+	// The final chunk is written separately from the data. It holds the digest
+	// and authenticates the trailer, which follows the end marker.
+	if err := cw.flushChunk(false, nil); err != nil {
+		return err
+	}
+	if err := cw.flushPending(); err != nil {
+		return err
+	}
+	cw.buf = append(cw.buf[:0], digest...)
+	finalSize := uint64(4 + nonceSize + len(digest) + cw.aead.Overhead())
+	t := trailer{
+		PlaintextSize:  plaintextSize,
+		CiphertextSize: cw.written + finalSize,
+	}
+	tb := t.bytes()
+	if err := cw.flushChunk(true, tb); err != nil {
 		return err
 	}
-
-	// Write end marker (4 zero bytes)
 	var endMarker [4]byte
-	if _, err := cw.dst.Write(endMarker[:]); err != nil {
-		return fmt.Errorf("writing end marker: %w", err)
+	if _, err := cw.dst.Write(append(endMarker[:], tb...)); err != nil {
+		return fmt.Errorf("writing trailer: %w", err)
 	}
 	return nil
 }
@@ -104,7 +191,10 @@ func (cw *chunkWriter) Close() error {
 // Writer is the public streaming encryption writer.
 type Writer struct {
 	chunks     *chunkWriter
-	gzipWriter *gzip.Writer // nil if no compression
+	compressor io.WriteCloser // nil if no compression
+	written    uint64         // plaintext bytes written
+	hash       hash.Hash      // SHA-256 of the plaintext written
+	digest     []byte         // sum of hash once closed
 	closed     bool
 	err        error // sticky error from first write failure
 }
@@ -113,12 +203,18 @@ type Writer struct {
 // Writer is compressed (if configured), encrypted in chunks, and written to dst.
 // The caller must call Close on the returned Writer to finalize the stream.
 func NewWriter(dst io.Writer, kp KeyProvider, opts ...Option) (*Writer, error) {
+	return NewWriterContext(context.Background(), dst, kp, opts...)
+}
+
+// NewWriterContext is like NewWriter but passes ctx to the KeyProvider when
+// generating the data key.
+func NewWriterContext(ctx context.Context, dst io.Writer, kp KeyProvider, opts ...Option) (*Writer, error) {
 	o := options{}
 	for _, fn := range opts {
 		fn(&o)
 	}
 
-	dk, err := kp.GenerateKey()
+	dk, err := generateKey(ctx, kp)
 	if err != nil {
 		return nil, fmt.Errorf("generating data key: %w", err)
 	}
@@ -128,53 +224,105 @@ func NewWriter(dst io.Writer, kp KeyProvider, opts ...Option) (*Writer, error) {
 		return nil, fmt.Errorf("generating nonce prefix: %w", err)
 	}
 
-	var flags byte
-	if o.compress {
-		flags |= flagGzip
+	if !supportedCodec(o.codec) {
+		return nil, fmt.Errorf("unsupported compression codec %v", o.codec)
+	}
+	if err := checkLevel(o.codec, o.level); err != nil {
+		return nil, err
+	}
+
+	chunkSize := o.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("chunk size %d exceeds maximum of %d", chunkSize, maxChunkSize)
 	}
 
 	h := &fileHeader{
 		Version:     formatVersion,
-		Flags:       flags,
 		NoncePrefix: prefix,
 		WrappedKey:  dk.WrappedKey,
+		ChunkSize:   uint32(chunkSize), //nolint:gosec // checked against maxChunkSize
+		Metadata:    o.metadata,
+		Cipher:      o.cipher,
+		Codec:       o.codec,
+	}
+	if h.Cipher == XChaCha20Poly1305 {
+		if _, err := rand.Read(h.XNoncePrefix[:]); err != nil {
+			return nil, fmt.Errorf("generating nonce prefix: %w", err)
+		}
+	}
+	if len(o.recipients) > 0 {
+		h.Stanzas, err = recipientStanzas(ctx, kp, dk, o.recipients)
+		if err != nil {
+			return nil, err
+		}
+		h.WrappedKey = nil
+	}
+	if err := validateHeader(h); err != nil {
+		return nil, err
 	}
 
 	if err := writeHeader(dst, h); err != nil {
 		return nil, fmt.Errorf("writing header: %w", err)
 	}
 
-	return newWriter(dst, dk.Plaintext, h, o.compress, o.chunkSize)
+	return newWriter(dst, dk.Plaintext, h, o.level, o.concurrency)
 }
 
-func newWriter(dst io.Writer, key []byte, h *fileHeader, compress bool, chunkSize int) (*Writer, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("creating AES cipher: %w", err)
+// recipientStanzas returns a key stanza for kp, which generated dk, and each recipient.
+func recipientStanzas(ctx context.Context, kp KeyProvider, dk *DataKey, recipients []KeyProvider) ([]Stanza, error) {
+	// A provider without a wrapped key uses its own key as the data key, which
+	// mustn't be handed to other recipients.
+	if len(dk.WrappedKey) == 0 {
+		return nil, errors.New("recipients require a KeyProvider which wraps data keys")
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	stanzas := []Stanza{{ProviderID: providerID(kp), WrappedKey: dk.WrappedKey}}
+	for i, r := range recipients {
+		wk, err := wrapKey(ctx, r, dk.Plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("wrapping data key for recipient %d: %w", i, err)
+		}
+		if len(wk) == 0 {
+			return nil, fmt.Errorf("recipient %d returned an empty wrapped key", i)
+		}
+		stanzas = append(stanzas, Stanza{ProviderID: providerID(r), WrappedKey: wk})
+	}
+	return stanzas, nil
+}
+
+// newWriter returns a Writer for the stream with header h, which has been written to
+// dst. Data is compressed with the header's codec at level.
+func newWriter(dst io.Writer, key []byte, h *fileHeader, level int, concurrency int) (*Writer, error) {
+	aead, err := newAEAD(key, h)
 	if err != nil {
-		return nil, fmt.Errorf("creating GCM: %w", err)
+		return nil, err
 	}
 
+	chunkSize := int(h.ChunkSize)
 	if chunkSize <= 0 {
 		chunkSize = DefaultChunkSize
 	}
 
 	cw := &chunkWriter{
 		dst:         dst,
-		gcm:         gcm,
+		aead:        aead,
 		noncePrefix: h.NoncePrefix,
 		headerAAD:   headerBytes(h),
 		buf:         make([]byte, 0, chunkSize),
 		chunkSize:   chunkSize,
+		concurrency: concurrency,
 	}
 
-	w := &Writer{chunks: cw}
+	w := &Writer{chunks: cw, hash: sha256.New()}
 
-	if compress {
-		w.gzipWriter = gzip.NewWriter(cw)
+	if codec := h.codec(); codec != CodecNone {
+		w.compressor, err = newCompressor(codec, level, cw)
+		if err != nil {
+			return nil, fmt.Errorf("creating %v writer: %w", codec, err)
+		}
 	}
 
 	return w, nil
@@ -189,11 +337,13 @@ func (w *Writer) Write(p []byte) (int, error) {
 	}
 	var n int
 	var err error
-	if w.gzipWriter != nil {
-		n, err = w.gzipWriter.Write(p)
+	if w.compressor != nil {
+		n, err = w.compressor.Write(p)
 	} else {
 		n, err = w.chunks.Write(p)
 	}
+	w.hash.Write(p[:n])
+	w.written += uint64(n) //nolint:gosec // n is never negative
 	if err != nil {
 		w.err = err
 	}
@@ -207,10 +357,17 @@ func (w *Writer) Close() error {
 		return ErrClosed
 	}
 	w.closed = true
-	if w.gzipWriter != nil {
-		if err := w.gzipWriter.Close(); err != nil {
-			return fmt.Errorf("closing gzip writer: %w", err)
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			return fmt.Errorf("closing compressor: %w", err)
 		}
 	}
-	return w.chunks.Close()
+	w.digest = w.hash.Sum(nil)
+	return w.chunks.close(w.written, w.digest)
+}
+
+// Digest returns the SHA-256 of the plaintext written, or nil before Close. It's
+// stored encrypted in the stream and checked by Reader and Verify.
+func (w *Writer) Digest() []byte {
+	return slices.Clone(w.digest)
 }
@@ -3,6 +3,7 @@ package stream
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"testing"
@@ -86,13 +87,14 @@ func TestWriterRoundTrip(t *testing.T) {
 			Version:     formatVersion,
 			Flags:       0,
 			NoncePrefix: prefix,
+			ChunkSize:   16,
 		}
 
 		var buf bytes.Buffer
 		err = writeHeader(&buf, h)
 		require.NoError(t, err)
 
-		w, err := newWriter(&buf, key, h, false, 16)
+		w, err := newWriter(&buf, key, h, 0, 0)
 		require.NoError(t, err)
 
 		// Write data in small increments
@@ -166,6 +168,112 @@ func TestNewWriterRoundTrip(t *testing.T) {
 	})
 }
 
+func TestConcurrency(t *testing.T) {
+	key := []byte("1234567890123456")
+	kp := NewStaticKeyProvider(key)
+
+	original := make([]byte, 1000)
+	_, err := rand.Read(original)
+	require.NoError(t, err)
+
+	for _, version := range []byte{formatVersion1, formatVersion3} {
+		for _, concurrency := range []int{0, 2, 3, 8} {
+			for _, size := range []int{0, 1, 99, 100, 101, 250, 1000} {
+				name := fmt.Sprintf("version %d concurrency %d size %d", version, concurrency, size)
+				t.Run(name, func(t *testing.T) {
+					if version != formatVersion {
+						// Writer only produces the current version, older ones are only read
+						data := writeVersionedTestData(t, version, key, original[:size], false, 100).Bytes()
+						r, err := NewReader(bytes.NewReader(data), kp, WithConcurrency(concurrency), WithLegacyV1())
+						require.NoError(t, err)
+						got, err := io.ReadAll(r)
+						require.NoError(t, err)
+						require.Equal(t, original[:size], got)
+						return
+					}
+
+					var prefix [noncePrefixSize]byte
+					_, err := rand.Read(prefix[:])
+					require.NoError(t, err)
+
+					write := func(concurrency int) []byte {
+						h := &fileHeader{Version: formatVersion, NoncePrefix: prefix, ChunkSize: 100}
+						var buf bytes.Buffer
+						require.NoError(t, writeHeader(&buf, h))
+
+						w, err := newWriter(&buf, key, h, 0, concurrency)
+						require.NoError(t, err)
+						// Write in pieces which don't line up with chunks
+						for data := original[:size]; len(data) > 0; {
+							n := min(len(data), 37)
+							_, err := w.Write(data[:n])
+							require.NoError(t, err)
+							data = data[n:]
+						}
+						require.NoError(t, w.Close())
+						return buf.Bytes()
+					}
+
+					// The format is the same regardless of concurrency
+					data := write(concurrency)
+					require.Equal(t, write(0), data)
+
+					r, err := NewReader(bytes.NewReader(data), kp, WithConcurrency(concurrency))
+					require.NoError(t, err)
+					got, err := io.ReadAll(r)
+					require.NoError(t, err)
+					require.Equal(t, original[:size], got)
+				})
+			}
+		}
+	}
+
+	t.Run("compressed", func(t *testing.T) {
+		original := bytes.Repeat([]byte("hello world "), 100_000)
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, kp, WithCompression(), WithChunkSize(1024), WithConcurrency(4))
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), kp, WithConcurrency(4))
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+
+	t.Run("error after plaintext", func(t *testing.T) {
+		data := writeTestData(t, key, original, false, 100).Bytes()
+		header, chunks, tail := splitChunks(t, data)
+		chunks[3][nonceSize] ^= 0xFF
+		data = joinChunks(header, chunks, tail)
+
+		for _, concurrency := range []int{0, 2, 8} {
+			r, err := NewReader(bytes.NewReader(data), kp, WithConcurrency(concurrency))
+			require.NoError(t, err)
+
+			// Chunks before the tampered one are still returned
+			got, err := io.ReadAll(r)
+			require.ErrorContains(t, err, "decrypting chunk 3")
+			require.Equal(t, original[:300], got)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data := writeTestData(t, key, original, false, 100).Bytes()
+		header, chunks, tail := splitChunks(t, data)
+		data = joinChunks(header, chunks[:len(chunks)-1], tail)
+
+		r, err := NewReader(bytes.NewReader(data), kp, WithConcurrency(4))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(t, err, ErrTruncated)
+	})
+}
+
 func TestWriterUseAfterClose(t *testing.T) {
 	key := []byte("1234567890123456")
 	kp := NewStaticKeyProvider(key)
@@ -201,9 +309,10 @@ func TestWriterUseAfterClose(t *testing.T) {
 		h := &fileHeader{
 			Version:     formatVersion,
 			NoncePrefix: prefix,
+			// Use a tiny chunk size so a single Write triggers a flush to dst
+			ChunkSize: 4,
 		}
-		// Use a tiny chunk size so a single Write triggers a flush to dst
-		w, err := newWriter(fw, key, h, false, 4)
+		w, err := newWriter(fw, key, h, 0, 0)
 		require.NoError(t, err)
 
 		// Write enough to fill a chunk and trigger a flush, which writes
@@ -220,26 +329,44 @@ func TestWriterUseAfterClose(t *testing.T) {
 func writeTestData(t *testing.T, key, data []byte, compress bool, chunkSize int) *bytes.Buffer {
 	t.Helper()
 
+	return writeVersionedTestData(t, formatVersion, key, data, compress, chunkSize)
+}
+
+func writeVersionedTestData(t *testing.T, version byte, key, data []byte, compress bool, chunkSize int) *bytes.Buffer {
+	t.Helper()
+
 	var prefix [noncePrefixSize]byte
 	_, err := rand.Read(prefix[:])
 	require.NoError(t, err)
 
-	var flags byte
-	if compress {
-		flags = flagGzip
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
 	}
 
 	h := &fileHeader{
-		Version:     formatVersion,
-		Flags:       flags,
+		Version:     version,
 		NoncePrefix: prefix,
+		ChunkSize:   uint32(chunkSize), //nolint:gosec // test chunk sizes are small
+	}
+	if compress {
+		// Version 1 records gzip with a flag
+		if version == formatVersion1 {
+			h.Flags = flagGzip
+		} else {
+			h.Codec = CodecGzip
+		}
 	}
 
 	var buf bytes.Buffer
 	err = writeHeader(&buf, h)
 	require.NoError(t, err)
 
-	w, err := newWriter(&buf, key, h, compress, chunkSize)
+	if version != formatVersion {
+		writeLegacyChunks(t, &buf, key, h, data)
+		return &buf
+	}
+
+	w, err := newWriter(&buf, key, h, 0, 0)
 	require.NoError(t, err)
 
 	if len(data) > 0 {
@@ -251,6 +378,49 @@ func writeTestData(t *testing.T, key, data []byte, compress bool, chunkSize int)
 	return &buf
 }
 
+// writeLegacyChunks writes the chunks and end marker of a version 1 stream, which
+// Writer no longer produces.
+func writeLegacyChunks(t *testing.T, buf *bytes.Buffer, key []byte, h *fileHeader, data []byte) {
+	t.Helper()
+
+	if codec := h.codec(); codec != CodecNone {
+		var compressed bytes.Buffer
+		cw, err := newCompressor(codec, 0, &compressed)
+		require.NoError(t, err)
+		_, err = cw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, cw.Close())
+		data = compressed.Bytes()
+	}
+
+	var chunks [][]byte
+	for chunkSize := int(h.ChunkSize); len(data) > 0; {
+		n := min(len(data), chunkSize)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+
+	aead, err := newAEAD(key, h)
+	require.NoError(t, err)
+
+	for i, plaintext := range chunks {
+		nonce := buildNonce(h.NoncePrefix, uint64(i))
+		var aad []byte
+		if i == 0 {
+			aad = headerBytes(h)
+		}
+		chunk := aead.Seal(nonce[:], nonce[:], plaintext, aad)
+
+		var lenBuf [4]byte
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(chunk))) //nolint:gosec // test chunks are small
+		buf.Write(lenBuf[:])
+		buf.Write(chunk)
+	}
+
+	// End marker
+	buf.Write([]byte{0, 0, 0, 0})
+}
+
 // failWriter is an io.Writer that returns an error after a set number of calls.
 type failWriter struct {
 	failAfter int // number of successful Write calls before failing
@@ -272,8 +442,7 @@ func readTestData(t *testing.T, key, data []byte) []byte {
 	h, aad, err := readHeader(r)
 	require.NoError(t, err)
 
-	compress := h.Flags&flagGzip != 0
-	dr, err := newReader(r, key, aad, compress)
+	dr, err := newReader(r, key, h, aad, 0)
 	require.NoError(t, err)
 
 	got, err := io.ReadAll(dr)
@@ -0,0 +1,183 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+
+	"github.com/moov-io/cryptfs/stream"
+)
+
+// SetStreamFormat makes Disfigure, WriteFile and Create write the CRFS stream format
+// (see the stream package) using the configured KeyProvider and opts. Data written in
+// the stream format is authenticated by the format itself, so the HMAC key and envelope
+// are not applied. The Coder is still applied to the output of Disfigure and WriteFile.
+//
+// opts are also passed to stream.NewReader, so options such as stream.WithConcurrency
+// apply to reading.
+func (fsys *FS) SetStreamFormat(opts ...stream.Option) {
+	if fsys != nil {
+		fsys.streamFormat = true
+		fsys.streamOptions = opts
+	}
+}
+
+// SetStreamReadOptions passes opts to stream.NewReader when Reveal, ReadFile and Open
+// read the CRFS stream format, without making writes use it. For example
+// stream.WithLegacyV1 reads streams written by older releases of stream.NewWriter.
+func (fsys *FS) SetStreamReadOptions(opts ...stream.Option) {
+	if fsys != nil {
+		fsys.streamReadOptions = opts
+	}
+}
+
+// streamReaderOptions returns the options of SetStreamFormat followed by those of
+// SetStreamReadOptions.
+func (fsys *FS) streamReaderOptions() []stream.Option {
+	return append(slices.Clip(fsys.streamOptions), fsys.streamReadOptions...)
+}
+
+// disfigureStream encrypts plaintext into the CRFS stream format.
+func (fsys *FS) disfigureStream(ctx context.Context, plaintext []byte) ([]byte, error) {
+	if fsys.keyProvider == nil {
+		return nil, errors.New("stream format requires a KeyProvider")
+	}
+
+	var buf bytes.Buffer
+	w, err := stream.NewWriterContext(ctx, &buf, fsys.keyProvider, fsys.streamOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+
+	bs, err := fsys.coder.Encode(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("encoding: %w", err)
+	}
+	return bs, nil
+}
+
+// readsStreams reports whether data in the CRFS stream format is detected. Streams
+// aren't covered by the HMAC, so when a key is set they're only read once enabled
+// with SetStreamFormat.
+func (fsys *FS) readsStreams() bool {
+	if fsys.keyProvider == nil {
+		return false
+	}
+	return len(fsys.hmacKey) <= 1 || fsys.streamFormat
+}
+
+// revealStream decrypts data written in the CRFS stream format.
+func (fsys *FS) revealStream(ctx context.Context, data []byte) ([]byte, error) {
+	r, err := stream.NewReaderContext(ctx, bytes.NewReader(data), fsys.keyProvider, fsys.streamReaderOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("decryption: %w", err)
+	}
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("decryption: %w", err)
+	}
+	if err := r.Close(); err != nil {
+		return nil, fmt.Errorf("decryption: %w", err)
+	}
+	return bs, nil
+}
+
+// openStream returns a streamFile when fd contains a CRFS stream. Otherwise the
+// contents of fd are returned for decrypting with Reveal.
+func (fsys *FS) openStream(name string, fd fs.File, info fs.FileInfo) (*streamFile, []byte, error) {
+	br := bufio.NewReader(fd)
+	if fsys.readsStreams() {
+		peek, _ := br.Peek(len("CRFS") + 1)
+		if stream.HasHeader(peek) {
+			r, err := stream.NewReader(br, fsys.keyProvider, fsys.streamReaderOptions()...)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decryption: %w", err)
+			}
+			return &streamFile{
+				info: fsys.plaintextInfo(name, info),
+				fd:   fd,
+				r:    r,
+			}, nil, nil
+		}
+	}
+	bs, err := io.ReadAll(br)
+	return nil, bs, err
+}
+
+// streamSize returns the plaintext size recorded in the trailer of the CRFS stream
+// at name, which is read without decrypting the file. ok is false when name isn't
+// a stream or its size isn't recorded.
+func (fsys *FS) streamSize(name string) (int64, bool) {
+	fd, err := fsys.openRaw(name)
+	if err != nil {
+		return 0, false
+	}
+	defer fd.Close()
+
+	info, err := stream.Inspect(fd)
+	if err != nil || info.PlaintextSize < 0 {
+		return 0, false
+	}
+	return info.PlaintextSize, true
+}
+
+// streamFile is a read-only fs.File which decrypts a CRFS stream as it's read.
+type streamFile struct {
+	info   fs.FileInfo
+	fd     fs.File
+	r      *stream.Reader
+	closed bool
+}
+
+func (f *streamFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *streamFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	return f.r.Read(p)
+}
+
+func (f *streamFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return errors.Join(f.r.Close(), f.fd.Close())
+}
+
+var _ fs.File = (&streamFile{})
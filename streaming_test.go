@@ -0,0 +1,423 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/cryptfs/stream"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevealStream(t *testing.T) {
+	key := []byte(strings.Repeat("1", 16))
+	kp := stream.NewStaticKeyProvider(key)
+
+	cc, err := NewAESCryptor(key)
+	require.NoError(t, err)
+
+	original := bytes.Repeat([]byte("hello, world "), 10_000)
+	streamed := writeStream(t, kp, original, stream.WithCompression())
+
+	t.Run("mixed formats", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+		fsys.SetCompression(Gzip())
+		fsys.SetCoder(Base64())
+		fsys.SetKeyProvider(kp)
+
+		plaintext, err := fsys.Reveal(streamed)
+		require.NoError(t, err)
+		require.Equal(t, original, plaintext)
+
+		legacy, err := fsys.Disfigure([]byte("legacy data"))
+		require.NoError(t, err)
+
+		plaintext, err = fsys.Reveal(legacy)
+		require.NoError(t, err)
+		require.Equal(t, "legacy data", string(plaintext))
+	})
+
+	t.Run("encoded stream", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+		fsys.SetCoder(Base64())
+		fsys.SetKeyProvider(kp)
+
+		encoded, err := Base64().Encode(streamed)
+		require.NoError(t, err)
+
+		plaintext, err := fsys.Reveal(encoded)
+		require.NoError(t, err)
+		require.Equal(t, original, plaintext)
+	})
+
+	t.Run("no KeyProvider", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+
+		_, err = fsys.Reveal(streamed)
+		require.ErrorContains(t, err, "decryption: ")
+
+		// Plaintext which looks like a stream is returned as-is
+		fsys, err = New(NoEncryption())
+		require.NoError(t, err)
+
+		plaintext, err := fsys.Reveal(streamed)
+		require.NoError(t, err)
+		require.Equal(t, streamed, plaintext)
+	})
+
+	t.Run("HMAC", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+		fsys.SetKeyProvider(kp)
+		fsys.SetHMACKey([]byte("hmac key"))
+
+		// Streams don't carry the MAC, so they're rejected unless enabled
+		_, err = fsys.Reveal(streamed)
+		require.ErrorContains(t, err, "invalid MAC")
+
+		fsys.SetStreamFormat()
+		plaintext, err := fsys.Reveal(streamed)
+		require.NoError(t, err)
+		require.Equal(t, original, plaintext)
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		// Written by stream.NewWriter before the final chunk was added
+		path := filepath.Join("testdata", "stream-v1.crfs")
+
+		fsys, err := FromConfig(Config{
+			Encryption: EncryptionConfig{
+				AES: &AESConfig{Key: string(key)},
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = fsys.ReadFile(path)
+		require.ErrorIs(t, err, stream.ErrLegacyVersion)
+
+		fsys.SetStreamReadOptions(stream.WithLegacyV1())
+		bs, err := fsys.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "hello, world\n", string(bs))
+
+		// Writes keep the original format
+		encrypted, err := fsys.Disfigure(original)
+		require.NoError(t, err)
+		require.False(t, stream.HasHeader(encrypted))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+		fsys.SetKeyProvider(stream.NewStaticKeyProvider([]byte(strings.Repeat("2", 16))))
+
+		_, err = fsys.Reveal(streamed)
+		require.ErrorContains(t, err, "decryption: ")
+	})
+}
+
+func TestStreamFiles(t *testing.T) {
+	key := []byte(strings.Repeat("1", 16))
+	kp := stream.NewStaticKeyProvider(key)
+
+	cc, err := NewAESCryptor(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	fsys, err := NewWithRoot(dir, cc)
+	require.NoError(t, err)
+	fsys.SetKeyProvider(kp)
+
+	original := bytes.Repeat([]byte("0123456789"), 20_000)
+	err = os.WriteFile(filepath.Join(dir, "data.bin"), writeStream(t, kp, original), 0600)
+	require.NoError(t, err)
+
+	t.Run("ReadFile", func(t *testing.T) {
+		bs, err := fsys.ReadFile("data.bin")
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		file, err := fsys.Open("data.bin")
+		require.NoError(t, err)
+
+		_, ok := file.(*streamFile)
+		require.True(t, ok)
+
+		bs, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+
+		info, err := file.Stat()
+		require.NoError(t, err)
+		require.Equal(t, int64(len(original)), info.Size())
+
+		require.NoError(t, file.Close())
+		require.Error(t, file.Close())
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(dir, "compressed.bin"), writeStream(t, kp, original, stream.WithCompression()), 0600)
+		require.NoError(t, err)
+
+		// The plaintext size is read from the stream's trailer without decrypting
+		fsys, err := NewWithRoot(dir, cc)
+		require.NoError(t, err)
+
+		for _, name := range []string{"data.bin", "compressed.bin"} {
+			info, err := fsys.Stat(name)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(original)), info.Size())
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		w, err := fsys.Create("created.bin")
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		bs, err := fsys.ReadFile("created.bin")
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+	})
+
+	t.Run("FromConfig", func(t *testing.T) {
+		fsys, err := FromConfig(Config{
+			Encryption: EncryptionConfig{
+				AES: &AESConfig{Key: string(key)},
+			},
+		})
+		require.NoError(t, err)
+
+		bs, err := fsys.ReadFile(filepath.Join(dir, "data.bin"))
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+	})
+}
+
+func writeStream(t *testing.T, kp stream.KeyProvider, data []byte, opts ...stream.Option) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := stream.NewWriter(&buf, kp, opts...)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestStreamFormat(t *testing.T) {
+	key := []byte(strings.Repeat("1", 16))
+	cc, err := NewAESCryptor(key)
+	require.NoError(t, err)
+
+	original := bytes.Repeat([]byte("hello, world "), 10_000)
+
+	t.Run("WriteFile", func(t *testing.T) {
+		dir := t.TempDir()
+		fsys, err := NewWithRoot(dir, cc)
+		require.NoError(t, err)
+		fsys.SetKeyProvider(stream.NewStaticKeyProvider(key))
+		fsys.SetStreamFormat(stream.WithChunkSize(1024), stream.WithCompression())
+
+		require.NoError(t, fsys.WriteFile("data.txt", original, 0600))
+
+		bs, err := os.ReadFile(filepath.Join(dir, "data.txt"))
+		require.NoError(t, err)
+		require.True(t, stream.HasHeader(bs))
+		require.Less(t, len(bs), len(original))
+
+		bs, err = fsys.ReadFile("data.txt")
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+	})
+
+	t.Run("Disfigure with encoding", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+		fsys.SetKeyProvider(stream.NewStaticKeyProvider(key))
+		fsys.SetCoder(Base64())
+		fsys.SetHMACKey([]byte(strings.Repeat("abcdef", 10)))
+		fsys.SetStreamFormat()
+
+		encoded, err := fsys.Disfigure(original)
+		require.NoError(t, err)
+
+		raw, err := Base64().Decode(encoded)
+		require.NoError(t, err)
+		require.True(t, stream.HasHeader(raw))
+
+		plaintext, err := fsys.Reveal(encoded)
+		require.NoError(t, err)
+		require.Equal(t, original, plaintext)
+	})
+
+	t.Run("no KeyProvider", func(t *testing.T) {
+		fsys, err := New(cc)
+		require.NoError(t, err)
+		fsys.SetStreamFormat()
+
+		_, err = fsys.Disfigure(original)
+		require.ErrorContains(t, err, "stream format requires a KeyProvider")
+	})
+
+	t.Run("FromConfig", func(t *testing.T) {
+		conf := Config{
+			Compression: CompressionConfig{
+				Gzip: &GzipConfig{},
+			},
+			Encryption: EncryptionConfig{
+				AES: &AESConfig{Key: string(key)},
+			},
+			Stream: &StreamConfig{
+				ChunkSize:   4096,
+				Concurrency: 4,
+			},
+		}
+		fsys, err := FromConfig(conf)
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "data.txt")
+		require.NoError(t, fsys.WriteFile(path, original, 0600))
+
+		bs, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.True(t, stream.HasHeader(bs))
+
+		// Each file gets its own data key wrapped with the AES key
+		info, err := stream.Inspect(bytes.NewReader(bs))
+		require.NoError(t, err)
+		require.NotEmpty(t, info.WrappedKey)
+
+		other, err := fsys.Disfigure(original)
+		require.NoError(t, err)
+		otherInfo, err := stream.Inspect(bytes.NewReader(other))
+		require.NoError(t, err)
+		require.NotEqual(t, info.WrappedKey, otherInfo.WrappedKey)
+
+		// Streams written with the AES key as a static key are still read
+		var static bytes.Buffer
+		w, err := stream.NewWriter(&static, stream.NewStaticKeyProvider(key))
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		bs, err = fsys.Reveal(static.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+
+		// Readers without the stream config read the file
+		conf.Stream = nil
+		reader, err := FromConfig(conf)
+		require.NoError(t, err)
+
+		bs, err = reader.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, original, bs)
+
+		// GPG has no KeyProvider
+		conf.Encryption = EncryptionConfig{
+			GPG: &GPGConfig{
+				PublicPath: filepath.Join("internal", "gpgx", "testdata", "key.pub"),
+			},
+		}
+		conf.Stream = &StreamConfig{}
+		_, err = FromConfig(conf)
+		require.ErrorContains(t, err, "requires AES or Vault encryption")
+	})
+
+	t.Run("FromConfig cipher", func(t *testing.T) {
+		conf := Config{
+			Encryption: EncryptionConfig{
+				AES: &AESConfig{Key: strings.Repeat("1", 32)},
+			},
+			Stream: &StreamConfig{
+				Cipher: "xchacha20-poly1305",
+			},
+		}
+		fsys, err := FromConfig(conf)
+		require.NoError(t, err)
+
+		encrypted, err := fsys.Disfigure(original)
+		require.NoError(t, err)
+
+		info, err := stream.Inspect(bytes.NewReader(encrypted))
+		require.NoError(t, err)
+		require.Equal(t, stream.XChaCha20Poly1305, info.Cipher)
+
+		decrypted, err := fsys.Reveal(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, original, decrypted)
+
+		conf.Stream.Cipher = "rc4"
+		_, err = FromConfig(conf)
+		require.ErrorContains(t, err, `unknown cipher "rc4"`)
+	})
+
+	t.Run("FromConfig codec", func(t *testing.T) {
+		conf := Config{
+			Encryption: EncryptionConfig{
+				AES: &AESConfig{Key: string(key)},
+			},
+			Stream: &StreamConfig{
+				Codec:            "zstd",
+				CompressionLevel: 19,
+			},
+		}
+		fsys, err := FromConfig(conf)
+		require.NoError(t, err)
+
+		encrypted, err := fsys.Disfigure(original)
+		require.NoError(t, err)
+		require.Less(t, len(encrypted), len(original)/10)
+
+		info, err := stream.Inspect(bytes.NewReader(encrypted))
+		require.NoError(t, err)
+		require.Equal(t, stream.CodecZstd, info.Codec)
+
+		decrypted, err := fsys.Reveal(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, original, decrypted)
+
+		conf.Stream.Codec = "lzma"
+		_, err = FromConfig(conf)
+		require.ErrorContains(t, err, `unknown codec "lzma"`)
+
+		conf.Stream.Codec = "gzip"
+		conf.Stream.CompressionLevel = 10
+		fsys, err = FromConfig(conf)
+		require.NoError(t, err)
+		_, err = fsys.Disfigure(original)
+		require.ErrorContains(t, err, "invalid gzip compression level 10")
+	})
+}
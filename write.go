@@ -0,0 +1,148 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/moov-io/cryptfs/stream"
+)
+
+// Create returns a writer which encrypts data written to it into the named file.
+// A new file is created with 0600 permissions, an existing file keeps its
+// permissions, and name is only replaced once Close returns successfully.
+//
+// After SetStreamFormat data is encrypted as it is written using the stream package
+// and the configured KeyProvider. The Coder isn't applied to streamed files, which
+// Reveal, ReadFile and Open detect without decoding. Otherwise the plaintext is
+// buffered and encrypted on Close just like WriteFile.
+func (fsys *FS) Create(name string) (io.WriteCloser, error) {
+	dir, path, err := fsys.localPath("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if fsys.streamFormat && fsys.keyProvider == nil {
+		return nil, fmt.Errorf("creating %s failed: stream format requires a KeyProvider", name)
+	}
+	file, err := createAtomic(dir, path, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s failed: %w", name, err)
+	}
+
+	w := &fileWriter{
+		fsys: fsys,
+		name: name,
+		file: file,
+	}
+	if fsys.streamFormat {
+		w.stream, err = stream.NewWriter(file, fsys.keyProvider, fsys.streamOptions...)
+		if err != nil {
+			file.Abort()
+			return nil, fmt.Errorf("creating %s failed: %w", name, err)
+		}
+	}
+	return w, nil
+}
+
+// fileWriter encrypts data into an atomicFile.
+type fileWriter struct {
+	fsys *FS
+	name string
+	file *atomicFile
+
+	stream *stream.Writer // nil when buffering plaintext
+	buf    bytes.Buffer
+
+	closed bool
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fs.ErrClosed}
+	}
+	if w.stream != nil {
+		return w.stream.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Close finishes encrypting and replaces the named file. Nothing is written to the
+// named file if an error is returned.
+func (w *fileWriter) Close() error {
+	if w.closed {
+		return &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+	}
+	w.closed = true
+
+	if err := w.finish(); err != nil {
+		w.file.Abort()
+		return fmt.Errorf("writing %s failed: %w", w.name, err)
+	}
+	if err := w.file.Commit(); err != nil {
+		return fmt.Errorf("writing %s failed: %w", w.name, err)
+	}
+	return nil
+}
+
+func (w *fileWriter) finish() error {
+	if w.stream != nil {
+		return w.stream.Close()
+	}
+	encodedBytes, err := w.fsys.Disfigure(w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(encodedBytes); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (fsys *FS) Remove(name string) error {
+	dir, path, err := fsys.localPath("remove", name)
+	if err != nil {
+		return err
+	}
+	return dir.Remove(path)
+}
+
+// Rename renames (moves) oldname to newname, replacing newname if it already exists.
+func (fsys *FS) Rename(oldname, newname string) error {
+	dir, oldpath, err := fsys.localPath("rename", oldname)
+	if err != nil {
+		return err
+	}
+	_, newpath, err := fsys.localPath("rename", newname)
+	if err != nil {
+		return err
+	}
+	return dir.Rename(oldpath, newpath)
+}
+
+// MkdirAll creates a directory named name along with any necessary parents.
+func (fsys *FS) MkdirAll(name string, perm fs.FileMode) error {
+	dir, path, err := fsys.localPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return dir.MkdirAll(path, perm)
+}
@@ -0,0 +1,158 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cryptfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/moov-io/cryptfs/stream"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreate(t *testing.T) {
+	key := []byte(strings.Repeat("1", 16))
+	cc, err := NewAESCryptor(key)
+	require.NoError(t, err)
+
+	t.Run("buffered", func(t *testing.T) {
+		dir := t.TempDir()
+		fsys, err := NewWithRoot(dir, cc)
+		require.NoError(t, err)
+		fsys.SetCoder(Base64())
+
+		w, err := fsys.Create("data.txt")
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte("hello, "))
+		require.NoError(t, err)
+
+		// Nothing is visible until Close
+		_, err = os.Stat(filepath.Join(dir, "data.txt"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = w.Write([]byte("world"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		bs, err := fsys.ReadFile("data.txt")
+		require.NoError(t, err)
+		require.Equal(t, "hello, world", string(bs))
+
+		_, err = w.Write([]byte("more"))
+		require.ErrorIs(t, err, fs.ErrClosed)
+		require.ErrorIs(t, w.Close(), fs.ErrClosed)
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		dir := t.TempDir()
+		fsys, err := NewWithRoot(dir, cc)
+		require.NoError(t, err)
+
+		kp := stream.NewStaticKeyProvider(key)
+		fsys.SetKeyProvider(kp)
+
+		original := bytes.Repeat([]byte("0123456789"), 20_000)
+
+		// A KeyProvider alone only reads streams
+		w, err := fsys.Create("buffered.bin")
+		require.NoError(t, err)
+		_, err = w.Write(original)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		bs, err := os.ReadFile(filepath.Join(dir, "buffered.bin"))
+		require.NoError(t, err)
+		require.False(t, stream.HasHeader(bs))
+
+		fsys.SetStreamFormat()
+		w, err = fsys.Create("large.bin")
+		require.NoError(t, err)
+		_, err = io.Copy(w, bytes.NewReader(original))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		fd, err := os.Open(filepath.Join(dir, "large.bin"))
+		require.NoError(t, err)
+		r, err := stream.NewReader(fd, kp)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, original, got)
+	})
+
+	t.Run("stream format without KeyProvider", func(t *testing.T) {
+		fsys, err := NewWithRoot(t.TempDir(), cc)
+		require.NoError(t, err)
+		fsys.SetStreamFormat()
+
+		_, err = fsys.Create("data.txt")
+		require.ErrorContains(t, err, "requires a KeyProvider")
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		fsys, err := Wrap(fstest.MapFS{}, cc)
+		require.NoError(t, err)
+
+		_, err = fsys.Create("data.txt")
+		require.ErrorIs(t, err, ErrReadOnly)
+	})
+}
+
+func TestRemoveRenameMkdirAll(t *testing.T) {
+	cc, err := NewAESCryptor([]byte(strings.Repeat("1", 16)))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	fsys, err := NewWithRoot(dir, cc)
+	require.NoError(t, err)
+
+	require.NoError(t, fsys.MkdirAll("a/b/c", 0700))
+	info, err := fsys.Stat("a/b/c")
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+
+	require.NoError(t, fsys.WriteFile("a/b/c/data.txt", []byte("data"), 0600))
+	require.NoError(t, fsys.Rename("a/b/c/data.txt", "a/moved.txt"))
+
+	bs, err := fsys.ReadFile("a/moved.txt")
+	require.NoError(t, err)
+	require.Equal(t, "data", string(bs))
+
+	_, err = fsys.Stat("a/b/c/data.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, fsys.Remove("a/moved.txt"))
+	_, err = fsys.Stat("a/moved.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	t.Run("path traversal", func(t *testing.T) {
+		require.ErrorIs(t, fsys.MkdirAll("../escape", 0700), fs.ErrInvalid)
+		require.ErrorIs(t, fsys.Remove("../escape"), fs.ErrInvalid)
+		require.ErrorIs(t, fsys.Rename("a", "../escape"), fs.ErrInvalid)
+		require.ErrorIs(t, fsys.Rename("../escape", "a"), fs.ErrInvalid)
+	})
+}